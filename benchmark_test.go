@@ -61,6 +61,27 @@ func BenchmarkUpdate_SimpleHash_1M(b *testing.B) {
 	benchmarkUpdate(b, 1_000_000, bigIntHasher, BigIntEqual)
 }
 
+func BenchmarkInsertMany_SHA256_1M(b *testing.B) {
+	benchmarkInsertMany(b, 1_000_000, SHA256Hasher, BigIntEqual)
+}
+
+// BenchmarkInsertMany_SHA256Bytes32_1M uses fixed-size nodes, which do not
+// allocate per hash
+func BenchmarkInsertMany_SHA256Bytes32_1M(b *testing.B) {
+	leaves := make([][32]byte, 1_000_000)
+	for j := range leaves {
+		big.NewInt(int64(j)).FillBytes(leaves[j][:])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree, _ := New(SHA256Bytes32Hasher, Bytes32Equal, nil, nil, nil)
+		if err := tree.InsertMany(leaves); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 // Helper functions for benchmarks
 
 func benchmarkInsertMany(b *testing.B, numLeaves int, hash Hasher[*big.Int], eq Equal[*big.Int]) {
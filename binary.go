@@ -0,0 +1,400 @@
+package leanimt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The binary snapshot format stores the leaves of a tree, which is rebuilt and
+// checked against the stored root when read. All integers are unsigned
+// varints unless noted otherwise.
+//
+//	header: "LIMT" | version (1 byte) | flags (1 byte) | hasher ID length |
+//	        hasher ID | size | width | root length | root | CRC-32C (4 bytes)
+//	chunk:  leaf count | payload length | payload | CRC-32C (4 bytes)
+//
+// Leaves are written in chunks following the header until size leaves have
+// been written. A chunk payload holds its leaves encoded by the encoder, each
+// one prefixed by its length if width is zero, or as width bytes otherwise.
+// With the zstd flag, payloads are zstd frames. Checksums are big-endian
+// CRC-32C (Castagnoli) of the header or chunk bytes that precede them.
+
+const (
+	binaryMagic   = "LIMT"
+	binaryVersion = 1
+
+	binaryFlagZstd = 1 << 0
+
+	// DefaultBinaryChunkSize is the number of leaves per chunk written by
+	// WriteBinary when BinaryOptions.ChunkSize is zero.
+	DefaultBinaryChunkSize = 1 << 16
+
+	// maxBinaryChunk bounds the size of a chunk payload, compressed or not,
+	// so malformed input can't trigger large allocations.
+	maxBinaryChunk = 64 << 20
+)
+
+// ErrChecksumMismatch is returned by ReadBinary when the header or a chunk
+// doesn't match its checksum.
+var ErrChecksumMismatch = errors.New("binary snapshot checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// BinaryOptions configures WriteBinary.
+type BinaryOptions struct {
+	// HasherID is the registered ID of the tree hasher, used by ReadBinary
+	// to select it. Defaults to the ID set WithHasherID.
+	HasherID string
+	// Width, if not zero, is the length of every encoded leaf, which is then
+	// written without a length prefix, e.g. 32 for Bytes32Encoder.
+	Width int
+	// ChunkSize is the number of leaves per chunk, DefaultBinaryChunkSize if
+	// zero.
+	ChunkSize int
+	// Compress compresses every chunk with zstd.
+	Compress bool
+}
+
+// WriteBinary writes the tree leaves to w in the binary snapshot format,
+// encoding them with encoder. Trees created WithLeafHasher write the leaves
+// as inserted. It writes a snapshot, so writers are not blocked while w is
+// written, as ExportTo does.
+func (t *LeanIMT[N]) WriteBinary(w io.Writer, encoder func(N) ([]byte, error), opts BinaryOptions) error {
+	s, release := t.tempSnapshot()
+	defer release()
+	return s.WriteBinary(w, encoder, opts)
+}
+
+// WriteBinary writes the snapshot to w, as LeanIMT.WriteBinary.
+func (s *Snapshot[N]) WriteBinary(w io.Writer, encoder func(N) ([]byte, error), opts BinaryOptions) error {
+	if encoder == nil {
+		return errors.New("parameter 'encoder' is not defined")
+	}
+	if opts.HasherID == "" {
+		opts.HasherID = s.hasherID
+	}
+	if opts.HasherID == "" {
+		return errors.New("binary snapshot needs a hasher ID")
+	}
+	if opts.Width < 0 || opts.ChunkSize < 0 {
+		return errors.New("invalid binary snapshot options")
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultBinaryChunkSize
+	}
+
+	var flags byte
+	var enc *zstd.Encoder
+	if opts.Compress {
+		flags |= binaryFlagZstd
+		var err error
+		if enc, err = zstd.NewWriter(nil); err != nil {
+			return err
+		}
+		defer enc.Close()
+	}
+
+	buf := append([]byte(binaryMagic), binaryVersion, flags)
+	buf = appendUvarint(buf, uint64(len(opts.HasherID)))
+	buf = append(buf, opts.HasherID...)
+	buf = appendUvarint(buf, uint64(len(s.values)))
+	buf = appendUvarint(buf, uint64(opts.Width))
+	if root, ok := s.Root(); ok {
+		var err error
+		if buf, err = appendValue(buf, root, encoder); err != nil {
+			return err
+		}
+	} else {
+		buf = appendUvarint(buf, 0)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, castagnoli))
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	var payload []byte
+	for from := 0; from < len(s.values); from += opts.ChunkSize {
+		leaves := s.values[from:min(from+opts.ChunkSize, len(s.values))]
+		var err error
+		if payload, err = appendLeaves(payload[:0], leaves, encoder, opts.Width); err != nil {
+			return err
+		}
+		stored := payload
+		if enc != nil {
+			stored = enc.EncodeAll(payload, nil)
+		}
+		if len(payload) > maxBinaryChunk || len(stored) > maxBinaryChunk {
+			return errors.New("binary snapshot chunk too large, reduce ChunkSize")
+		}
+		buf = appendUvarint(buf[:0], uint64(len(leaves)))
+		buf = appendUvarint(buf, uint64(len(stored)))
+		buf = append(buf, stored...)
+		buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, castagnoli))
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// appendLeaves appends the chunk payload of leaves to buf.
+func appendLeaves[N any](buf []byte, leaves []N, encoder func(N) ([]byte, error), width int) ([]byte, error) {
+	for _, leaf := range leaves {
+		if width == 0 {
+			var err error
+			if buf, err = appendValue(buf, leaf, encoder); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		b, err := encoder(leaf)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != width {
+			return nil, errors.New("leaf encoding is " + itoa(len(b)) + " bytes, expected " + itoa(width))
+		}
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
+
+// ReadBinary reads a tree written by WriteBinary from r, decoding its leaves
+// with decoder. The hasher is looked up by the ID stored in the header, as
+// NewNamed does, and the rebuilt tree must have the stored root, or
+// ErrRootMismatch is returned. Corrupted data is reported with
+// ErrChecksumMismatch. Optional features are enabled through opts; trees
+// written WithLeafHasher must be read with the same leaf hasher.
+func ReadBinary[N any](r io.Reader, eq Equal[N], decoder func([]byte) (N, error), opts ...Option[N]) (*LeanIMT[N], error) {
+	if decoder == nil {
+		return nil, errors.New("parameter 'decoder' is not defined")
+	}
+	br := &checksumReader{r: bufio.NewReader(r), crc: crc32.New(castagnoli)}
+	h, err := br.header()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := LookupHasher[N](h.hasherID)
+	if err != nil {
+		return nil, err
+	}
+	var root N
+	if h.size > 0 {
+		if root, err = decoder(h.root); err != nil {
+			return nil, err
+		}
+	}
+
+	var dec *zstd.Decoder
+	if h.flags&binaryFlagZstd != 0 {
+		if dec, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxBinaryChunk)); err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+	}
+	capacity := h.size
+	if capacity > DefaultBinaryChunkSize {
+		capacity = DefaultBinaryChunkSize
+	}
+	leaves := make([]N, 0, capacity)
+	for uint64(len(leaves)) < h.size {
+		count, payload, err := br.chunk()
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 || count > h.size-uint64(len(leaves)) {
+			return nil, errors.New("binary snapshot chunk has a wrong number of leaves")
+		}
+		if dec != nil {
+			if payload, err = dec.DecodeAll(payload, nil); err != nil {
+				return nil, err
+			}
+		}
+		// every leaf takes at least one byte
+		if count > uint64(len(payload)) {
+			return nil, errors.New("binary snapshot chunk has a wrong size")
+		}
+		if leaves, err = readLeaves(leaves, payload, int(count), h.width, decoder); err != nil {
+			return nil, err
+		}
+	}
+
+	tree, err := importTree(hash, eq, append(slices.Clip(opts), WithHasherID[N](h.hasherID)))
+	if err != nil {
+		return nil, err
+	}
+	tree.resetLeaves(leaves)
+	if err := tree.rebuildTree(context.Background(), nil); err != nil {
+		return nil, err
+	}
+	if got, ok := tree.rootUnsafe(); ok && !tree.equal(got, root) {
+		return nil, ErrRootMismatch
+	}
+	if err := tree.afterImport(); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// readLeaves decodes the count leaves of a chunk payload and appends them to
+// leaves.
+func readLeaves[N any](leaves []N, payload []byte, count, width int, decoder func([]byte) (N, error)) ([]N, error) {
+	if width > 0 && (len(payload)%width != 0 || len(payload)/width != count) {
+		return nil, errors.New("binary snapshot chunk has a wrong size")
+	}
+	r := &byteReader{data: payload}
+	for range count {
+		var leaf N
+		var err error
+		if width == 0 {
+			leaf, err = readValue(r, decoder)
+		} else {
+			leaf, err = decoder(r.data[:width])
+			r.data = r.data[width:]
+		}
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, leaf)
+	}
+	if len(r.data) != 0 {
+		return nil, errors.New("trailing data after binary snapshot leaves")
+	}
+	return leaves, nil
+}
+
+// binaryHeader holds the header fields of a binary snapshot.
+type binaryHeader struct {
+	flags    byte
+	hasherID string
+	size     uint64
+	width    int
+	root     []byte
+}
+
+// checksumReader reads the header and chunks of a binary snapshot, computing
+// the checksum of the bytes read.
+type checksumReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+// ReadByte implements io.ByteReader for binary.ReadUvarint.
+func (r *checksumReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+// bytes reads n bytes, at most maxBinaryChunk.
+func (r *checksumReader) bytes(n uint64) ([]byte, error) {
+	if n > maxBinaryChunk {
+		return nil, errors.New("binary snapshot field too large")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	r.crc.Write(b)
+	return b, nil
+}
+
+// uvarint reads an unsigned varint.
+func (r *checksumReader) uvarint() (uint64, error) {
+	x, err := binary.ReadUvarint(r)
+	return x, unexpectedEOF(err)
+}
+
+// verify reads a checksum and compares it with the one of the bytes read
+// since the previous call.
+func (r *checksumReader) verify() error {
+	var sum [4]byte
+	if _, err := io.ReadFull(r.r, sum[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != r.crc.Sum32() {
+		return ErrChecksumMismatch
+	}
+	r.crc.Reset()
+	return nil
+}
+
+// header reads and verifies the snapshot header.
+func (r *checksumReader) header() (binaryHeader, error) {
+	var h binaryHeader
+	start, err := r.bytes(uint64(len(binaryMagic) + 2))
+	if err != nil {
+		return h, err
+	}
+	if string(start[:len(binaryMagic)]) != binaryMagic {
+		return h, errors.New("not a binary tree snapshot")
+	}
+	if start[len(binaryMagic)] != binaryVersion {
+		return h, errors.New("unsupported binary snapshot version " + itoa(int(start[len(binaryMagic)])))
+	}
+	h.flags = start[len(binaryMagic)+1]
+	n, err := r.uvarint()
+	if err != nil {
+		return h, err
+	}
+	id, err := r.bytes(n)
+	if err != nil {
+		return h, err
+	}
+	h.hasherID = string(id)
+	if h.size, err = r.uvarint(); err != nil {
+		return h, err
+	}
+	width, err := r.uvarint()
+	if err != nil {
+		return h, err
+	}
+	if width > maxBinaryChunk {
+		return h, errors.New("binary snapshot field too large")
+	}
+	h.width = int(width)
+	if n, err = r.uvarint(); err != nil {
+		return h, err
+	}
+	if h.root, err = r.bytes(n); err != nil {
+		return h, err
+	}
+	return h, r.verify()
+}
+
+// chunk reads and verifies the next chunk, returning its leaf count and its
+// stored payload.
+func (r *checksumReader) chunk() (uint64, []byte, error) {
+	count, err := r.uvarint()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := r.uvarint()
+	if err != nil {
+		return 0, nil, err
+	}
+	payload, err := r.bytes(n)
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, payload, r.verify()
+}
+
+// unexpectedEOF reports a snapshot ending early as io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package leanimt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"slices"
+	"testing"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	tree, _ := NewNamed(HasherSHA256, BigIntEqual, nil, nil, nil)
+	// leaves sharing their high bytes, so chunks compress
+	leaves := manyLeaves(1, 1000)
+	for _, leaf := range leaves {
+		leaf.SetBit(leaf, 250, 1)
+	}
+	if err := tree.InsertMany(leaves); err != nil {
+		t.Fatal(err)
+	}
+	root, _ := tree.Root()
+
+	sizes := make(map[bool]int)
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := tree.WriteBinary(&buf, BigIntEncoder, BinaryOptions{ChunkSize: 300, Compress: compress}); err != nil {
+			t.Fatal(err)
+		}
+		sizes[compress] = buf.Len()
+		read, err := ReadBinary(&buf, BigIntEqual, BigIntDecoder, WithKeyIndex(BigIntKey))
+		if err != nil {
+			t.Fatalf("compress=%v: %v", compress, err)
+		}
+		if r, _ := read.Root(); r.Cmp(root) != 0 || !slices.EqualFunc(read.Leaves(), tree.Leaves(), BigIntEqual) {
+			t.Fatalf("compress=%v: read tree differs", compress)
+		}
+		if read.hasherID != HasherSHA256 || read.IndexOf(leaves[499]) != 499 {
+			t.Fatalf("compress=%v: options not applied", compress)
+		}
+	}
+	if sizes[true] >= sizes[false] {
+		t.Fatalf("compressed snapshot is %d bytes, uncompressed %d", sizes[true], sizes[false])
+	}
+
+	empty, _ := NewNamed(HasherSHA256, BigIntEqual, nil, nil, nil)
+	var buf bytes.Buffer
+	if err := empty.WriteBinary(&buf, BigIntEncoder, BinaryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if read, err := ReadBinary(&buf, BigIntEqual, BigIntDecoder); err != nil || read.Size() != 0 {
+		t.Fatalf("unexpected empty tree %v", err)
+	}
+}
+
+func TestBinaryFixedWidth(t *testing.T) {
+	tree, _ := New(Keccak256Bytes32Hasher, Bytes32Equal, nil, nil, nil)
+	for i := range 10 {
+		tree.Insert([32]byte{31: byte(i)})
+	}
+	var buf bytes.Buffer
+	opts := BinaryOptions{HasherID: HasherKeccak256Bytes32, Width: 32}
+	if err := tree.WriteBinary(&buf, Bytes32Encoder, opts); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadBinary(&buf, Bytes32Equal, Bytes32Decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(read.Leaves(), tree.Leaves()) {
+		t.Fatal("read leaves differ")
+	}
+
+	opts.Width = 31
+	if err := tree.WriteBinary(io.Discard, Bytes32Encoder, opts); err == nil {
+		t.Fatal("expected error for leaves of the wrong width")
+	}
+}
+
+func TestBinaryCorruption(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	if err := tree.InsertMany(manyLeaves(1, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.WriteBinary(io.Discard, BigIntEncoder, BinaryOptions{}); err == nil {
+		t.Fatal("expected error without hasher ID")
+	}
+	var buf bytes.Buffer
+	if err := tree.WriteBinary(&buf, BigIntEncoder, BinaryOptions{HasherID: HasherSHA256, ChunkSize: 8}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// the leaves were hashed with another hasher than the one named
+	if _, err := ReadBinary(bytes.NewReader(data), BigIntEqual, BigIntDecoder); !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("expected ErrRootMismatch, got %v", err)
+	}
+	for _, pos := range []int{6, len(data) - 10} {
+		bad := slices.Clone(data)
+		bad[pos] ^= 1
+		if _, err := ReadBinary(bytes.NewReader(bad), BigIntEqual, BigIntDecoder); !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("byte %d: expected ErrChecksumMismatch, got %v", pos, err)
+		}
+	}
+	if _, err := ReadBinary(bytes.NewReader(data[:len(data)-3]), BigIntEqual, BigIntDecoder); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if _, err := ReadBinary(bytes.NewReader([]byte("[[]]")), BigIntEqual, BigIntDecoder); err == nil {
+		t.Fatal("expected error for a JSON export")
+	}
+	if _, err := ReadBinary(bytes.NewReader(data), BigIntEqual, BigIntDecoder, WithExpectedRoot(big.NewInt(1))); err == nil {
+		t.Fatal("expected error for an unexpected root")
+	}
+}
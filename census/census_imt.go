@@ -1,13 +1,16 @@
 package census
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"math/big"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -27,6 +30,13 @@ type CensusIMT struct {
 	weights        map[string]*big.Int // hex address -> weight
 	db             db.Database         // optional persistence
 	mu             sync.RWMutex
+	observer       leanimt.Observer // optional operation observer
+	treeObserver   leanimt.Observer // observer of the census trees, counting their hashes
+	hashes         *atomic.Int64    // hashes computed, if observed
+	written        *atomic.Int64    // bytes written, if observed
+	feed           leanimt.RootFeed[*big.Int]
+	hasherID       string // registered name of hasher (optional)
+	leafHash       leanimt.LeafHasher[*big.Int]
 }
 
 // CensusProof contains all data needed for census membership verification
@@ -68,6 +78,19 @@ func isEmptyParticipant(p CensusParticipant) bool {
 	return p.Weight.Sign() == 0
 }
 
+// SchemaVersion is the version of the census storage schema.
+const SchemaVersion = 1
+
+// Schema is the storage schema of the census index and weight entries,
+// checked by Load. Databases written before meta:census_version existed are
+// version 0. The tree stored next to them follows leanimt.TreeSchema.
+var Schema = leanimt.NewSchema("meta:census_version", "meta:census_size", SchemaVersion)
+
+func init() {
+	// version 1 only added meta:census_version
+	_ = Schema.Register(0, func(db.Database) error { return nil })
+}
+
 // Errors
 var (
 	ErrAddressAlreadyExists = errors.New("address already exists in census")
@@ -78,20 +101,24 @@ var (
 )
 
 // NewCensusIMT creates a new census tree with the provided database
-func NewCensusIMT(database db.Database, hasher leanimt.Hasher[*big.Int]) (*CensusIMT, error) {
-	tree, err := leanimt.New(hasher, leanimt.BigIntEqual, database, leanimt.BigIntEncoder, leanimt.BigIntDecoder)
-	if err != nil {
-		return nil, fmt.Errorf("error initializing lean-imt tree: %w", err)
-	}
-
+func NewCensusIMT(database db.Database, hasher leanimt.Hasher[*big.Int], opts ...Option) (*CensusIMT, error) {
 	census := &CensusIMT{
-		tree:           tree,
 		hasher:         hasher,
 		addressIndex:   make(map[string]int),
 		indexToAddress: make(map[int]string),
 		weights:        make(map[string]*big.Int),
 		db:             database,
 	}
+	for _, opt := range opts {
+		opt(census)
+	}
+	census.observe()
+
+	tree, err := census.newTree()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing lean-imt tree: %w", err)
+	}
+	census.tree = tree
 
 	// Load existing data
 	if err := census.Load(); err != nil && err != db.ErrKeyNotFound {
@@ -101,20 +128,53 @@ func NewCensusIMT(database db.Database, hasher leanimt.Hasher[*big.Int]) (*Censu
 	return census, nil
 }
 
+// newTree creates the underlying LeanIMT for a census. Leaves are indexed by
+// value so applyEvents can resolve leaf positions in constant time.
+func (c *CensusIMT) newTree() (*leanimt.LeanIMT[*big.Int], error) {
+	return leanimt.New(c.hasher, leanimt.BigIntEqual, c.db, leanimt.BigIntEncoder, leanimt.BigIntDecoder, c.treeOptions()...)
+}
+
+// treeOptions returns the options of the census LeanIMT.
+func (c *CensusIMT) treeOptions() []leanimt.Option[*big.Int] {
+	return []leanimt.Option[*big.Int]{
+		leanimt.WithKeyIndex(leanimt.BigIntKey), leanimt.WithObserver[*big.Int](c.treeObserver),
+		leanimt.WithHasherID[*big.Int](c.hasherID), leanimt.WithLeafHasher(c.leafHash),
+	}
+}
+
 // NewCensusIMTWithPebble creates a census tree with Pebble persistence
-func NewCensusIMTWithPebble(datadir string, hasher leanimt.Hasher[*big.Int]) (*CensusIMT, error) {
+func NewCensusIMTWithPebble(datadir string, hasher leanimt.Hasher[*big.Int], opts ...Option) (*CensusIMT, error) {
 	database, err := metadb.New(db.TypePebble, datadir)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewCensusIMT(database, hasher)
+	return NewCensusIMT(database, hasher, opts...)
+}
+
+// WithHasherID records id as the registered name of the census hasher, for
+// hashers registered under several IDs. Registered hashers are recorded
+// without it, and opening a census built with a different hasher fails with
+// leanimt.ErrHasherMismatch. See leanimt.WithHasherID.
+func WithHasherID(id string) Option {
+	return func(c *CensusIMT) {
+		c.hasherID = id
+	}
+}
+
+// WithLeafHasher hashes the packed address and weight of every participant
+// before committing it to the tree, see leanimt.WithLeafHasher. Proofs must
+// then be verified in-circuit with MerkleProof.VerifyWithLeafHasher.
+func WithLeafHasher(leafHash leanimt.LeafHasher[*big.Int]) Option {
+	return func(c *CensusIMT) {
+		c.leafHash = leafHash
+	}
 }
 
 // Add adds an address with its voting weight to the census
-func (c *CensusIMT) Add(address common.Address, weight *big.Int) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *CensusIMT) Add(address common.Address, weight *big.Int) (err error) {
+	op := c.lock(OpAdd)
+	defer func() { c.unlock(op, 1, err) }()
 
 	hexAddr := address.Hex()
 	if _, exists := c.addressIndex[hexAddr]; exists {
@@ -125,6 +185,7 @@ func (c *CensusIMT) Add(address common.Address, weight *big.Int) error {
 	packed := PackAddressWeight(address.Big(), weight)
 
 	// Insert into tree
+	old, _ := c.tree.Root()
 	c.tree.Insert(packed)
 
 	// Update indices
@@ -140,12 +201,13 @@ func (c *CensusIMT) Add(address common.Address, weight *big.Int) error {
 		}
 	}
 
+	c.publish(old, func() []int { return []int{newIndex} })
 	return nil
 }
 
 // AddBulk adds multiple addresses with their voting weights to the census in a single transaction
 // This is more efficient than calling Add() multiple times as it batches database operations
-func (c *CensusIMT) AddBulk(addresses []common.Address, weights []*big.Int) error {
+func (c *CensusIMT) AddBulk(addresses []common.Address, weights []*big.Int) (err error) {
 	if len(addresses) != len(weights) {
 		return errors.New("addresses and weights slices must have the same length")
 	}
@@ -154,8 +216,8 @@ func (c *CensusIMT) AddBulk(addresses []common.Address, weights []*big.Int) erro
 		return nil // Nothing to add
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	op := c.lock(OpAddBulk)
+	defer func() { c.unlock(op, len(addresses), err) }()
 
 	// Pre-validate all addresses don't already exist
 	for _, address := range addresses {
@@ -175,6 +237,7 @@ func (c *CensusIMT) AddBulk(addresses []common.Address, weights []*big.Int) erro
 	}
 
 	// Insert all values into tree
+	old, _ := c.tree.Root()
 	startingIndex := c.tree.Size()
 	for _, packed := range packedValues {
 		c.tree.Insert(packed)
@@ -195,14 +258,21 @@ func (c *CensusIMT) AddBulk(addresses []common.Address, weights []*big.Int) erro
 		}
 	}
 
+	c.publish(old, func() []int {
+		indices := make([]int, len(addresses))
+		for i := range indices {
+			indices[i] = startingIndex + i
+		}
+		return indices
+	})
 	return nil
 }
 
 // Update updates the voting weight for an existing address in the census. If
 // the address does not exist, ErrAddressNotFound is returned.
-func (c *CensusIMT) Update(address common.Address, newWeight *big.Int) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *CensusIMT) Update(address common.Address, newWeight *big.Int) (err error) {
+	op := c.lock(OpUpdate)
+	defer func() { c.unlock(op, 1, err) }()
 	// Look up index
 	hexAddr := address.Hex()
 	// If not found, return error
@@ -213,6 +283,7 @@ func (c *CensusIMT) Update(address common.Address, newWeight *big.Int) error {
 	// Pack address and new weight
 	packed := PackAddressWeight(address.Big(), newWeight)
 	// Update tree at index
+	old, _ := c.tree.Root()
 	if err := c.tree.Update(index, packed); err != nil {
 		return err
 	}
@@ -224,13 +295,14 @@ func (c *CensusIMT) Update(address common.Address, newWeight *big.Int) error {
 			return err
 		}
 	}
+	c.publish(old, func() []int { return []int{index} })
 	return nil
 }
 
 // GenerateProof generates a census proof for an address
-func (c *CensusIMT) GenerateProof(address common.Address) (*CensusProof, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+func (c *CensusIMT) GenerateProof(address common.Address) (_ *CensusProof, err error) {
+	op := c.rlock(OpGenerateProof)
+	defer func() { c.unlock(op, 1, err) }()
 
 	hexAddr := address.Hex()
 
@@ -442,7 +514,7 @@ func (c *CensusIMT) dumpRangeBatched(pw *io.PipeWriter, start, end int) {
 
 // persistEntry saves a single entry atomically
 func (c *CensusIMT) persistEntry(hexAddr string, index int, weight *big.Int) error {
-	tx := c.db.WriteTx()
+	tx := c.writeTx()
 	defer tx.Discard()
 
 	// Save index mapping
@@ -461,7 +533,7 @@ func (c *CensusIMT) persistEntry(hexAddr string, index int, weight *big.Int) err
 	}
 
 	// Update census size
-	if err := tx.Set([]byte("meta:census_size"), encodeInt(c.tree.Size())); err != nil {
+	if err := writeMeta(tx, c.tree.Size()); err != nil {
 		return err
 	}
 
@@ -470,7 +542,7 @@ func (c *CensusIMT) persistEntry(hexAddr string, index int, weight *big.Int) err
 
 // persistBulkEntries saves multiple entries in a single transaction
 func (c *CensusIMT) persistBulkEntries(hexAddrs []string, weights []*big.Int, startingIndex int) error {
-	tx := c.db.WriteTx()
+	tx := c.writeTx()
 	defer tx.Discard()
 
 	// Save all entries in the transaction
@@ -494,18 +566,30 @@ func (c *CensusIMT) persistBulkEntries(hexAddrs []string, weights []*big.Int, st
 	}
 
 	// Update census size once at the end
-	if err := tx.Set([]byte("meta:census_size"), encodeInt(c.tree.Size())); err != nil {
+	if err := writeMeta(tx, c.tree.Size()); err != nil {
 		return err
 	}
 
 	return tx.Commit()
 }
 
-// Load restores the census from disk
+// writeMeta stores the census size and schema version in tx.
+func writeMeta(tx db.WriteTx, size int) error {
+	if err := tx.Set([]byte("meta:census_size"), encodeInt(size)); err != nil {
+		return err
+	}
+	return tx.Set([]byte(Schema.VersionKey()), encodeInt(SchemaVersion))
+}
+
+// Load restores the census from disk. Databases written with an older
+// census schema are upgraded first.
 func (c *CensusIMT) Load() error {
 	if c.db == nil {
 		return nil
 	}
+	if err := Schema.Upgrade(c.db); err != nil {
+		return err
+	}
 
 	// Load census size
 	sizeBytes, err := c.db.Get([]byte("meta:census_size"))
@@ -544,9 +628,9 @@ func (c *CensusIMT) Load() error {
 }
 
 // Sync ensures all data is persisted to disk
-func (c *CensusIMT) Sync() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *CensusIMT) Sync() (err error) {
+	op := c.lock(OpSync)
+	defer func() { c.unlock(op, 0, err) }()
 
 	// Sync the tree
 	if err := c.tree.Sync(); err != nil {
@@ -623,25 +707,18 @@ func (c *CensusIMT) DumpAll() (*CensusDump, error) {
 // The import validates that the resulting merkle root matches the dump's root.
 // This method will clear any existing census data before importing.
 func (c *CensusIMT) ImportAll(dump *CensusDump) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// Reset state to prevent conflicts
-	if err := c.resetPersistentState(); err != nil {
-		return err
-	}
+	return c.ImportAllContext(context.Background(), dump, nil)
+}
 
-	// Clear existing data
-	c.addressIndex = make(map[string]int)
-	c.indexToAddress = make(map[int]string)
-	c.weights = make(map[string]*big.Int)
-
-	// Recreate tree
-	var err error
-	c.tree, err = leanimt.New(c.hasher, leanimt.BigIntEqual, c.db, leanimt.BigIntEncoder, leanimt.BigIntDecoder)
-	if err != nil {
-		return err
-	}
+// ImportAllContext is like ImportAll but stops when ctx is done, returning
+// ctx.Err(). The dump is built and validated on a separate in-memory tree,
+// which is written to storage in the same transaction that removes the
+// existing census and then replaces it, so a cancelled, invalid or failed
+// import leaves the census unchanged, in memory and in storage. If progress
+// is not nil it reports the hashing of the imported leaves.
+func (c *CensusIMT) ImportAllContext(ctx context.Context, dump *CensusDump, progress leanimt.ProgressFunc) (err error) {
+	op := c.lock(OpImport)
+	defer func() { c.unlock(op, len(dump.Participants), err) }()
 
 	// Sort entries by index to ensure correct insertion order
 	participants := make([]CensusParticipant, len(dump.Participants))
@@ -650,53 +727,71 @@ func (c *CensusIMT) ImportAll(dump *CensusDump) error {
 
 	// Track expected index for validation
 	expectedIndex := uint64(0)
+	leaves := make([]*big.Int, 0, len(participants))
 	weights := []*big.Int{}
 	hexAddrs := []string{}
+	addressIndex := make(map[string]int)
+	indexToAddress := make(map[int]string)
+	weightByAddr := make(map[string]*big.Int)
 
 	for _, p := range participants {
 		// Fill gaps with empty entries if needed
 		for expectedIndex < p.AddressIndex {
-			c.tree.Insert(big.NewInt(0))
+			leaves = append(leaves, big.NewInt(0))
 			expectedIndex++
 		}
 
 		// Check if this is an empty entry
 		if isEmptyParticipant(p) {
 			// Insert zero value for empty entry
-			c.tree.Insert(big.NewInt(0))
+			leaves = append(leaves, big.NewInt(0))
 		} else {
 			// Insert actual participant
-			packed := PackAddressWeight(p.Address.Big(), p.Weight)
-			c.tree.Insert(packed)
+			leaves = append(leaves, PackAddressWeight(p.Address.Big(), p.Weight))
 
 			// Track for maps and persistence
 			hexAddr := p.Address.Hex()
-			c.addressIndex[hexAddr] = int(p.AddressIndex)
-			c.indexToAddress[int(p.AddressIndex)] = hexAddr
-			c.weights[hexAddr] = new(big.Int).Set(p.Weight)
+			addressIndex[hexAddr] = int(p.AddressIndex)
+			indexToAddress[int(p.AddressIndex)] = hexAddr
+			weightByAddr[hexAddr] = new(big.Int).Set(p.Weight)
 
 			hexAddrs = append(hexAddrs, hexAddr)
 			weights = append(weights, p.Weight)
 		}
 		expectedIndex++
 	}
-
-	// Verify root matches
-	root, ok := c.tree.Root()
-	if !ok {
+	if len(leaves) == 0 {
 		return fmt.Errorf("%w: imported census is empty", ErrEmptyCensus)
 	}
+
+	// Build the tree in memory and verify root matches
+	tree, err := leanimt.New(c.hasher, leanimt.BigIntEqual, nil, nil, nil, c.treeOptions()...)
+	if err != nil {
+		return err
+	}
+	if err := tree.InsertManyContext(ctx, leaves, progress); err != nil {
+		return err
+	}
+	root, _ := tree.Root()
 	if root.Cmp(dump.Root) != 0 {
 		return fmt.Errorf("%w: imported root does not match (expected %s, got %s)",
 			ErrBadCensusDump, dump.Root.String(), root.String())
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	// Persist if database exists
+	// Write the verified tree and census to storage, replacing the stored
+	// ones in the same transaction
 	if c.db != nil {
-		if err := c.persistImportedData(hexAddrs, weights); err != nil {
-			return fmt.Errorf("failed to persist imported data: %w", err)
+		if err := c.persistImport(ctx, tree, hexAddrs, weights, addressIndex); err != nil {
+			return fmt.Errorf("failed to persist imported census: %w", err)
 		}
 	}
+	c.tree = tree
+	c.addressIndex = addressIndex
+	c.indexToAddress = indexToAddress
+	c.weights = weightByAddr
 
 	return nil
 }
@@ -706,26 +801,26 @@ func (c *CensusIMT) ImportAll(dump *CensusDump) error {
 // This method will replace any existing census data.
 // Note: Unlike ImportAll, this method does not verify the merkle root since
 // the stream format doesn't include it. Use ImportAll for root verification.
-func (c *CensusIMT) Import(root *big.Int, reader io.Reader) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *CensusIMT) Import(root *big.Int, reader io.Reader) (err error) {
+	op, imported := c.lock(OpImport), 0
+	defer func() { c.unlock(op, imported, err) }()
 
 	// Reset state to prevent conflicts
 	if err := c.resetPersistentState(); err != nil {
 		return err
 	}
 
-	// Clear existing data
-	c.addressIndex = make(map[string]int)
-	c.indexToAddress = make(map[int]string)
-	c.weights = make(map[string]*big.Int)
-
 	// Recreate tree
-	var err error
-	c.tree, err = leanimt.New(c.hasher, leanimt.BigIntEqual, c.db, leanimt.BigIntEncoder, leanimt.BigIntDecoder)
+	tree, err := c.newTree()
 	if err != nil {
 		return err
 	}
+	c.tree = tree
+
+	// Clear existing data
+	c.addressIndex = make(map[string]int)
+	c.indexToAddress = make(map[int]string)
+	c.weights = make(map[string]*big.Int)
 
 	// Read and sort participants
 	decoder := json.NewDecoder(reader)
@@ -775,6 +870,7 @@ func (c *CensusIMT) Import(root *big.Int, reader io.Reader) error {
 		}
 		expectedIndex++
 	}
+	imported = c.tree.Size()
 
 	// Verify root matches
 	newRoot, ok := c.tree.Root()
@@ -796,6 +892,23 @@ func (c *CensusIMT) Import(root *big.Int, reader io.Reader) error {
 	return nil
 }
 
+// Subscribe returns a subscription to the root changes committed by Add,
+// AddBulk, Update and ApplyEvents. Changes are published in commit order
+// while the census is locked, without waiting for subscribers.
+func (c *CensusIMT) Subscribe(buffer int, policy leanimt.Backpressure) *leanimt.Subscription[*big.Int] {
+	return c.feed.Subscribe(buffer, policy)
+}
+
+// publish notifies the subscribers of the change from old to the current
+// root. indices is only called if there are subscribers.
+func (c *CensusIMT) publish(old *big.Int, indices func() []int) {
+	if !c.feed.Active() {
+		return
+	}
+	root, _ := c.tree.Root()
+	c.feed.Publish(leanimt.RootChange[*big.Int]{OldRoot: old, NewRoot: root, Size: c.tree.Size(), Indices: indices()})
+}
+
 // CensusEvent represents a single weight change event fetched from the
 // GraphQL endpoint. It contains the account address, previous weight, and
 // new weight.
@@ -837,7 +950,10 @@ func (e CensusEvent) treeOp() treeOp {
 	}
 }
 
-func (c *CensusIMT) applyEvents(events []CensusEvent) error {
+// applyEvents applies events to the tree and the in-memory indices, returning
+// the positions of the modified leaves in increasing order.
+func (c *CensusIMT) applyEvents(events []CensusEvent) ([]int, error) {
+	touched := make(map[int]struct{})
 	for _, event := range events {
 		// Process each event
 		addr := event.Address.Hex()
@@ -857,14 +973,14 @@ func (c *CensusIMT) applyEvents(events []CensusEvent) error {
 				break
 			}
 			if err := c.tree.Update(index, newLeaf); err != nil {
-				return fmt.Errorf("failed to update address %s: %w", addr, err)
+				return nil, fmt.Errorf("failed to update address %s: %w", addr, err)
 			}
 		case treeOpDelete:
 			// CRITICAL: tree.Update(index, 0) sets the leaf to 0 but KEEPS the
 			// slot. The tree size doesn't decrease, it maintains an empty slot
 			// at that index.
 			if err := c.tree.Update(index, big.NewInt(0)); err != nil {
-				return fmt.Errorf("failed to delete address %s: %w", addr, err)
+				return nil, fmt.Errorf("failed to delete address %s: %w", addr, err)
 			}
 			// Remove from in-memory indices
 			delete(c.addressIndex, addr)
@@ -875,12 +991,13 @@ func (c *CensusIMT) applyEvents(events []CensusEvent) error {
 			updateIndexes = true
 			// Update existing leaf
 			if err := c.tree.Update(index, newLeaf); err != nil {
-				return fmt.Errorf("failed to update address %s: %w", addr, err)
+				return nil, fmt.Errorf("failed to update address %s: %w", addr, err)
 			}
 		case treeOpNoOp:
 			// No operation needed
 			continue
 		}
+		touched[index] = struct{}{}
 
 		// Update in-memory indices for inserts and updates
 		if updateIndexes {
@@ -889,23 +1006,31 @@ func (c *CensusIMT) applyEvents(events []CensusEvent) error {
 			c.weights[addr] = new(big.Int).Set(event.NewWeight)
 		}
 	}
-	return nil
+	return slices.Sorted(maps.Keys(touched)), nil
 }
 
 // ApplyEvents applies a list of CensusEvent to the existing census, updating
 // inserts, updates, and deletions as specified. The final tree root after
 // applying all events must match the provided root.
-func (c *CensusIMT) ApplyEvents(events []CensusEvent) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *CensusIMT) ApplyEvents(events []CensusEvent) (err error) {
+	op := c.lock(OpApplyEvents)
+	defer func() { c.unlock(op, len(events), err) }()
 
 	// Apply events to update the census
-	if err := c.applyEvents(events); err != nil {
+	old, _ := c.tree.Root()
+	indices, err := c.applyEvents(events)
+	if err != nil {
 		return err
 	}
 
 	// Sync tree state
-	return c.tree.Sync()
+	if err := c.tree.Sync(); err != nil {
+		return err
+	}
+	if len(indices) > 0 {
+		c.publish(old, func() []int { return indices })
+	}
+	return nil
 }
 
 // ImportEvents imports census changes from a list of CensusEvent, applying
@@ -913,9 +1038,9 @@ func (c *CensusIMT) ApplyEvents(events []CensusEvent) error {
 // applying all events must match the provided root.
 //
 // This method resets any existing census data before applying events.
-func (c *CensusIMT) ImportEvents(root *big.Int, events []CensusEvent) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *CensusIMT) ImportEvents(root *big.Int, events []CensusEvent) (err error) {
+	op := c.lock(OpImport)
+	defer func() { c.unlock(op, len(events), err) }()
 
 	// Reset state to prevent conflicts
 	if err := c.resetPersistentState(); err != nil {
@@ -928,7 +1053,7 @@ func (c *CensusIMT) ImportEvents(root *big.Int, events []CensusEvent) error {
 	c.weights = make(map[string]*big.Int)
 
 	// Apply events to update the census
-	if err := c.applyEvents(events); err != nil {
+	if _, err := c.applyEvents(events); err != nil {
 		return err
 	}
 
@@ -951,12 +1076,25 @@ func (c *CensusIMT) ImportEvents(root *big.Int, events []CensusEvent) error {
 
 // persistImportedData saves all imported data in a single transaction
 func (c *CensusIMT) persistImportedData(hexAddrs []string, weights []*big.Int) error {
-	tx := c.db.WriteTx()
+	tx := c.writeTx()
 	defer tx.Discard()
 
-	// Save all entries
+	if err := writeEntries(tx, hexAddrs, weights, c.addressIndex); err != nil {
+		return err
+	}
+
+	// Update census size
+	if err := writeMeta(tx, c.tree.Size()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writeEntries stores in tx the index mappings and weight of every address.
+func writeEntries(tx db.WriteTx, hexAddrs []string, weights []*big.Int, addressIndex map[string]int) error {
 	for i, hexAddr := range hexAddrs {
-		index := c.addressIndex[hexAddr]
+		index := addressIndex[hexAddr]
 
 		// Save index mapping
 		if err := tx.Set([]byte("idx:addr:"+hexAddr), encodeInt(index)); err != nil {
@@ -973,15 +1111,70 @@ func (c *CensusIMT) persistImportedData(hexAddrs []string, weights []*big.Int) e
 			return err
 		}
 	}
+	return nil
+}
+
+// persistImport writes an imported tree and its census entries in a single
+// transaction, which also removes the index and weight entries of the
+// current census. If it fails the stored census is left unchanged.
+func (c *CensusIMT) persistImport(ctx context.Context, tree *leanimt.LeanIMT[*big.Int], hexAddrs []string, weights []*big.Int, addressIndex map[string]int) error {
+	tx := c.writeTx()
+	defer tx.Discard()
+
+	// Stale entries are deleted first, so the imported ones overwrite them
+	if err := c.deleteIndexEntries(tx); err != nil {
+		return err
+	}
+
+	// The tree is written through tx too; its stale leaves and metadata are
+	// replaced by Persist
+	storage := &txDatabase{Database: c.db, tx: tx}
+	if err := tree.PersistContext(ctx, storage, leanimt.BigIntEncoder, leanimt.BigIntDecoder, nil); err != nil {
+		return err
+	}
+
+	if err := writeEntries(tx, hexAddrs, weights, addressIndex); err != nil {
+		return err
+	}
 
 	// Update census size
-	if err := tx.Set([]byte("meta:census_size"), encodeInt(c.tree.Size())); err != nil {
+	if err := writeMeta(tx, tree.Size()); err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// later syncs of the tree write to the database directly
+	storage.tx = nil
+	return nil
+}
+
+// txDatabase is a database whose write transactions join tx, so the writes
+// made through it are committed along with tx. Once tx is nil it writes to
+// the database directly.
+type txDatabase struct {
+	db.Database
+	tx db.WriteTx
 }
 
+// WriteTx returns tx, or a new transaction of the database if tx is nil.
+func (d *txDatabase) WriteTx() db.WriteTx {
+	if d.tx == nil {
+		return d.Database.WriteTx()
+	}
+	return joinedTx{d.tx}
+}
+
+// joinedTx is a transaction committed or discarded by its owner.
+type joinedTx struct {
+	db.WriteTx
+}
+
+func (joinedTx) Commit() error { return nil }
+
+func (joinedTx) Discard() {}
+
 // resetPersistentState removes any previously persisted census and tree data so imports
 // start from a clean slate. Without this, a persisted tree would be loaded by
 // leanimt.New and new leaves would be appended after the old ones, yielding a
@@ -991,7 +1184,7 @@ func (c *CensusIMT) resetPersistentState() error {
 		return nil
 	}
 
-	tx := c.db.WriteTx()
+	tx := c.writeTx()
 	defer tx.Discard()
 
 	// Remove tree leaves using the current in-memory size when available.
@@ -1017,6 +1210,7 @@ func (c *CensusIMT) resetPersistentState() error {
 		[]byte("meta:size"),
 		[]byte("meta:version"),
 		[]byte("meta:census_size"),
+		[]byte(Schema.VersionKey()),
 	}
 	for _, key := range metaKeys {
 		if err := tx.Delete(key); err != nil && err != db.ErrKeyNotFound {
@@ -1024,7 +1218,16 @@ func (c *CensusIMT) resetPersistentState() error {
 		}
 	}
 
-	// Clear index and weight entries we know about from the current census.
+	if err := c.deleteIndexEntries(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteIndexEntries deletes in tx the index and weight entries we know about
+// from the current census.
+func (c *CensusIMT) deleteIndexEntries(tx db.WriteTx) error {
 	for addr := range c.addressIndex {
 		if err := tx.Delete([]byte("idx:addr:" + addr)); err != nil && err != db.ErrKeyNotFound {
 			return err
@@ -1038,8 +1241,7 @@ func (c *CensusIMT) resetPersistentState() error {
 			return err
 		}
 	}
-
-	return tx.Commit()
+	return nil
 }
 
 // Helper functions for integer encoding/decoding
@@ -2,8 +2,12 @@ package census
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"math/big"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -721,6 +725,181 @@ func TestCensusIMT_DumpAllImportAll(t *testing.T) {
 	}
 }
 
+func TestCensusIMT_ImportAllContextLeavesCensusOnFailure(t *testing.T) {
+	census, err := NewCensusIMTWithPebble(t.TempDir(), leanimt.PoseidonHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = census.Close() }()
+	existing := common.HexToAddress("0x01")
+	if err := census.Add(existing, big.NewInt(5)); err != nil {
+		t.Fatal(err)
+	}
+	rootBefore, _ := census.Root()
+
+	source, err := NewCensusIMT(nil, leanimt.PoseidonHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		if err := source.Add(common.BigToAddress(big.NewInt(int64(100+i))), big.NewInt(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dump, err := source.DumpAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := census.ImportAllContext(ctx, dump, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	bad := *dump
+	bad.Root = big.NewInt(1)
+	if err := census.ImportAllContext(context.Background(), &bad, nil); !errors.Is(err, ErrBadCensusDump) {
+		t.Fatalf("expected ErrBadCensusDump, got %v", err)
+	}
+	if root, _ := census.Root(); root.Cmp(rootBefore) != 0 || !census.Has(existing) {
+		t.Fatal("census modified by a failed import")
+	}
+
+	var last leanimt.Progress
+	if err := census.ImportAllContext(context.Background(), dump, func(p leanimt.Progress) { last = p }); err != nil {
+		t.Fatal(err)
+	}
+	if root, _ := census.Root(); root.Cmp(dump.Root) != 0 || census.Has(existing) {
+		t.Fatal("census not replaced by the import")
+	}
+	if last.Leaves != 10 || last.Total != 10 {
+		t.Fatalf("unexpected last progress %+v", last)
+	}
+}
+
+func TestCensusIMT_ImportAllKeepsStorageOnWriteError(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := &failingDB{Database: database}
+	census, err := NewCensusIMT(failing, leanimt.PoseidonHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := common.HexToAddress("0x01")
+	if err := census.Add(existing, big.NewInt(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := census.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	rootBefore, _ := census.Root()
+
+	source, err := NewCensusIMT(nil, leanimt.PoseidonHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		if err := source.Add(common.BigToAddress(big.NewInt(int64(100+i))), big.NewInt(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dump, err := source.DumpAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing.failLeaves = true
+	if err := census.ImportAll(dump); !errors.Is(err, errLeafWrite) {
+		t.Fatalf("expected errLeafWrite, got %v", err)
+	}
+	if root, _ := census.Root(); root.Cmp(rootBefore) != 0 || !census.Has(existing) {
+		t.Fatal("census modified by a failed import")
+	}
+	reopened, err := NewCensusIMT(database, leanimt.PoseidonHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, _ := reopened.Root(); root.Cmp(rootBefore) != 0 || !reopened.Has(existing) {
+		t.Fatal("stored census modified by a failed import")
+	}
+
+	failing.failLeaves = false
+	if err := census.ImportAll(dump); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err = NewCensusIMT(database, leanimt.PoseidonHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, _ := reopened.Root(); root.Cmp(dump.Root) != 0 || reopened.Has(existing) || reopened.Size() != 10 {
+		t.Fatal("stored census not replaced by the import")
+	}
+}
+
+var (
+	errLeafWrite = errors.New("leaf write failed")
+	errRead      = errors.New("read failed")
+)
+
+// failingDB fails to write tree leaves while failLeaves is set, and to read
+// while failReads is set.
+type failingDB struct {
+	db.Database
+	failLeaves bool
+	failReads  bool
+}
+
+func (d *failingDB) Get(key []byte) ([]byte, error) {
+	if d.failReads {
+		return nil, errRead
+	}
+	return d.Database.Get(key)
+}
+
+func (d *failingDB) WriteTx() db.WriteTx {
+	return failingTx{WriteTx: d.Database.WriteTx(), db: d}
+}
+
+type failingTx struct {
+	db.WriteTx
+	db *failingDB
+}
+
+func (tx failingTx) Set(key, value []byte) error {
+	if tx.db.failLeaves && bytes.HasPrefix(key, []byte("leaf:")) {
+		return errLeafWrite
+	}
+	return tx.WriteTx.Set(key, value)
+}
+
+func TestCensusIMT_ImportReadError(t *testing.T) {
+	database, err := metadb.New(db.TypeInMem, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := &failingDB{Database: database}
+	observer := leanimt.ObserverFunc(func(leanimt.OpStats) {})
+	census, err := NewCensusIMT(failing, leanimt.PoseidonHasher, WithObserver(observer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := census.Add(common.HexToAddress("0x01"), big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	root, _ := census.Root()
+
+	// the new tree can't be loaded
+	failing.failReads = true
+	if err := census.Import(root, strings.NewReader("")); !errors.Is(err, errRead) {
+		t.Fatalf("expected errRead, got %v", err)
+	}
+	if census.tree == nil {
+		t.Fatal("census tree cleared by a failed import")
+	}
+}
+
 func TestCensusIMT_DumpImport(t *testing.T) {
 	tempDir := t.TempDir()
 	census, err := NewCensusIMTWithPebble(tempDir, leanimt.PoseidonHasher)
@@ -933,3 +1112,176 @@ func TestCensusIMT_ImportEvents(t *testing.T) {
 		t.Fatalf("Failed to import events: %v", err)
 	}
 }
+
+func TestCensusIMT_Subscribe(t *testing.T) {
+	census, err := NewCensusIMT(nil, leanimt.PoseidonHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := census.Subscribe(8, leanimt.DropOldest)
+	defer sub.Close()
+
+	addrs := []common.Address{common.HexToAddress("0x01"), common.HexToAddress("0x02"), common.HexToAddress("0x03")}
+	if err := census.Add(addrs[0], big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	change := <-sub.C()
+	if change.OldRoot != nil || change.Size != 1 || !slices.Equal(change.Indices, []int{0}) {
+		t.Fatalf("unexpected add change %+v", change)
+	}
+	if err := census.AddBulk(addrs[1:], []*big.Int{big.NewInt(2), big.NewInt(3)}); err != nil {
+		t.Fatal(err)
+	}
+	// a single change for the whole batch
+	bulk := <-sub.C()
+	if bulk.OldRoot.Cmp(change.NewRoot) != 0 || bulk.Size != 3 || !slices.Equal(bulk.Indices, []int{1, 2}) {
+		t.Fatalf("unexpected add bulk change %+v", bulk)
+	}
+	if err := census.Update(addrs[1], big.NewInt(5)); err != nil {
+		t.Fatal(err)
+	}
+	if change := <-sub.C(); !slices.Equal(change.Indices, []int{1}) {
+		t.Fatalf("unexpected update change %+v", change)
+	}
+
+	events := []CensusEvent{
+		{Address: addrs[2], PrevWeight: big.NewInt(3), NewWeight: big.NewInt(0)},
+		{Address: common.HexToAddress("0x04"), PrevWeight: big.NewInt(0), NewWeight: big.NewInt(4)},
+		{Address: addrs[0], PrevWeight: big.NewInt(1), NewWeight: big.NewInt(7)},
+	}
+	if err := census.ApplyEvents(events); err != nil {
+		t.Fatal(err)
+	}
+	change = <-sub.C()
+	root, _ := census.Root()
+	if change.NewRoot.Cmp(root) != 0 || change.Size != 4 || !slices.Equal(change.Indices, []int{0, 2, 3}) {
+		t.Fatalf("unexpected apply events change %+v", change)
+	}
+
+	// failed operations publish nothing
+	if err := census.Add(addrs[0], big.NewInt(1)); err == nil {
+		t.Fatal("expected an error")
+	}
+	select {
+	case change := <-sub.C():
+		t.Fatalf("unexpected change %+v", change)
+	default:
+	}
+}
+
+func TestCensusIMT_Observer(t *testing.T) {
+	var stats []leanimt.OpStats
+	observer := leanimt.ObserverFunc(func(s leanimt.OpStats) { stats = append(stats, s) })
+	census, err := NewCensusIMTWithPebble(t.TempDir(), leanimt.PoseidonHasher, WithObserver(observer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer census.Close()
+
+	byOp := func(op leanimt.Op) leanimt.OpStats {
+		for i := len(stats) - 1; i >= 0; i-- {
+			if stats[i].Op == op {
+				return stats[i]
+			}
+		}
+		t.Fatalf("operation %s not reported", op)
+		return leanimt.OpStats{}
+	}
+
+	addrs := []common.Address{common.HexToAddress("0x01"), common.HexToAddress("0x02"), common.HexToAddress("0x03")}
+	if err := census.AddBulk(addrs, []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(3)}); err != nil {
+		t.Fatal(err)
+	}
+	// the census reports the hashes of the tree inserts it runs
+	if s := byOp(OpAddBulk); s.Leaves != 3 || s.Hashes != 2 || s.BytesWritten == 0 || s.Err != nil {
+		t.Fatalf("unexpected add bulk stats %+v", s)
+	}
+	if s := byOp(leanimt.OpInsert); s.Leaves != 1 {
+		t.Fatalf("unexpected tree insert stats %+v", s)
+	}
+
+	if err := census.Add(addrs[0], big.NewInt(1)); !errors.Is(err, ErrAddressAlreadyExists) {
+		t.Fatalf("expected ErrAddressAlreadyExists, got %v", err)
+	}
+	if s := byOp(OpAdd); !errors.Is(s.Err, ErrAddressAlreadyExists) {
+		t.Fatalf("unexpected add stats %+v", s)
+	}
+
+	if _, err := census.GenerateProof(addrs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if s := byOp(OpGenerateProof); s.Leaves != 1 || s.Hashes != 0 {
+		t.Fatalf("unexpected proof stats %+v", s)
+	}
+	if err := census.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if s := byOp(leanimt.OpSync); s.BytesWritten == 0 {
+		t.Fatalf("unexpected tree sync stats %+v", s)
+	}
+	byOp(OpSync)
+}
+
+func TestCensusIMT_HasherMismatch(t *testing.T) {
+	database, err := metadb.New(db.TypeInMem, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	census, err := NewCensusIMT(database, leanimt.PoseidonHasher, WithHasherID(leanimt.HasherPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := census.Add(common.HexToAddress("0x01"), big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := census.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCensusIMT(database, leanimt.MiMC7Hasher, WithHasherID(leanimt.HasherMiMC7)); !errors.Is(err, leanimt.ErrHasherMismatch) {
+		t.Fatalf("expected ErrHasherMismatch, got %v", err)
+	}
+	if _, err := NewCensusIMT(database, leanimt.PoseidonHasher, WithHasherID(leanimt.HasherPoseidon)); err != nil {
+		t.Fatal(err)
+	}
+
+	// observed censuses keep the registered hasher
+	observer := leanimt.ObserverFunc(func(leanimt.OpStats) {})
+	if _, err := NewCensusIMT(database, leanimt.PoseidonHasher, WithObserver(observer)); err != nil {
+		t.Fatal(err)
+	}
+	observed, err := NewCensusIMT(database, leanimt.PoseidonHasher, WithObserver(observer), WithHasherID(leanimt.HasherPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump, err := census.DumpAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := observed.ImportAll(dump); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCensusIMT_SchemaVersion(t *testing.T) {
+	database, err := metadb.New(db.TypeInMem, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	census, err := NewCensusIMT(database, leanimt.PoseidonHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := census.Add(common.HexToAddress("0x01"), big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	if version, _, _ := Schema.StoredVersion(database); version != SchemaVersion {
+		t.Fatalf("stored version %d, want %d", version, SchemaVersion)
+	}
+
+	if err := Schema.Stamp(database, SchemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCensusIMT(database, leanimt.PoseidonHasher); !errors.Is(err, leanimt.ErrUnsupportedSchema) {
+		t.Fatalf("expected ErrUnsupportedSchema, got %v", err)
+	}
+}
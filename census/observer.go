@@ -0,0 +1,139 @@
+package census
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/vocdoni/davinci-node/db"
+	leanimt "github.com/vocdoni/lean-imt-go"
+)
+
+// Operations reported by CensusIMT. The operations of the underlying tree are
+// reported separately with the leanimt operation names.
+const (
+	OpAdd           leanimt.Op = "census_add"
+	OpAddBulk       leanimt.Op = "census_add_bulk"
+	OpUpdate        leanimt.Op = "census_update"
+	OpGenerateProof leanimt.Op = "census_generate_proof"
+	OpImport        leanimt.Op = "census_import"
+	OpApplyEvents   leanimt.Op = "census_apply_events"
+	OpSync          leanimt.Op = "census_sync"
+)
+
+// Option configures a CensusIMT.
+type Option func(*CensusIMT)
+
+// WithObserver reports the statistics of every census operation, and of the
+// operations of the underlying tree, to o. Hashes count every hash computed
+// by the census trees during the operation, and BytesWritten the census
+// index entries written, excluding the tree storage reported by the tree.
+func WithObserver(o leanimt.Observer) Option {
+	return func(c *CensusIMT) {
+		c.observer = o
+	}
+}
+
+// observe counts the hashes computed by the census trees, as reported by
+// their operations, if an observer is set. The census hasher is not wrapped,
+// so the trees still record its registered ID.
+func (c *CensusIMT) observe() {
+	if c.observer == nil {
+		return
+	}
+	hashes := new(atomic.Int64)
+	c.treeObserver = leanimt.ObserverFunc(func(stats leanimt.OpStats) {
+		hashes.Add(stats.Hashes)
+		c.observer.Observe(stats)
+	})
+	c.hashes, c.written = hashes, new(atomic.Int64)
+}
+
+// opState tracks a census operation between lock and unlock.
+type opState struct {
+	op      leanimt.Op
+	write   bool
+	start   time.Time
+	wait    time.Duration
+	hashes  int64
+	written int64
+}
+
+// lock acquires the write lock for op, timing the wait if observed.
+func (c *CensusIMT) lock(op leanimt.Op) opState {
+	return c.acquire(op, true)
+}
+
+// rlock acquires the read lock for op, timing the wait if observed.
+func (c *CensusIMT) rlock(op leanimt.Op) opState {
+	return c.acquire(op, false)
+}
+
+// acquire takes the read or write lock for op.
+func (c *CensusIMT) acquire(op leanimt.Op, write bool) opState {
+	s := opState{op: op, write: write}
+	if c.observer != nil {
+		s.start = time.Now()
+	}
+	if write {
+		c.mu.Lock()
+	} else {
+		c.mu.RLock()
+	}
+	if c.observer != nil {
+		s.wait = time.Since(s.start)
+		s.hashes, s.written = c.hashes.Load(), c.written.Load()
+	}
+	return s
+}
+
+// unlock releases the lock taken for s and reports the operation.
+func (c *CensusIMT) unlock(s opState, leaves int, err error) {
+	var hashes, written int64
+	if c.observer != nil {
+		hashes = c.hashes.Load() - s.hashes
+		written = c.written.Load() - s.written
+	}
+	if s.write {
+		c.mu.Unlock()
+	} else {
+		c.mu.RUnlock()
+	}
+	if c.observer != nil {
+		c.observer.Observe(leanimt.OpStats{
+			Op:           s.op,
+			Start:        s.start,
+			Duration:     time.Since(s.start),
+			LockWait:     s.wait,
+			Hashes:       hashes,
+			Leaves:       leaves,
+			BytesWritten: written,
+			Err:          err,
+		})
+	}
+}
+
+// writeTx opens a write transaction that counts the bytes written if the
+// census is observed.
+func (c *CensusIMT) writeTx() db.WriteTx {
+	if c.observer == nil {
+		return c.db.WriteTx()
+	}
+	return &countingTx{WriteTx: c.db.WriteTx(), written: c.written}
+}
+
+// countingTx counts the bytes set through a write transaction.
+type countingTx struct {
+	db.WriteTx
+	written *atomic.Int64
+}
+
+// Set stores key and value, counting their size.
+func (tx *countingTx) Set(key, value []byte) error {
+	tx.written.Add(int64(len(key) + len(value)))
+	return tx.WriteTx.Set(key, value)
+}
+
+// Unwrap returns the wrapped transaction.
+func (tx *countingTx) Unwrap() db.WriteTx {
+	return tx.WriteTx
+}
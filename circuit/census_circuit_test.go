@@ -7,6 +7,7 @@ import (
 	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend"
 	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
 	"github.com/consensys/gnark/test"
 	"github.com/ethereum/go-ethereum/common"
 	leanimt "github.com/vocdoni/lean-imt-go"
@@ -273,3 +274,128 @@ func TestVerifyCensusProof_EdgeCases(t *testing.T) {
 		t.Log("✅ Maximum weight census proof verified")
 	})
 }
+
+// censusPoseidon2Circuit verifies census proofs of trees built with Poseidon2
+type censusPoseidon2Circuit censusProofCircuit
+
+func (circuit *censusPoseidon2Circuit) Define(api frontend.API) error {
+	proof := NewMerkleProof(api, circuit.Address, circuit.Weight,
+		circuit.PathBits, circuit.LeafIndex, circuit.Siblings)
+	isValid, err := proof.VerifyWith(api, circuit.Root, Poseidon2Hash)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(isValid, 1)
+	return nil
+}
+
+func TestVerifyCensusProof_Poseidon2(t *testing.T) {
+	for _, tc := range []struct {
+		curve  ecc.ID
+		hasher leanimt.Hasher[*big.Int]
+	}{
+		{ecc.BN254, leanimt.Poseidon2Hasher},
+		{ecc.BLS12_377, leanimt.Poseidon2BLS12377Hasher},
+	} {
+		t.Run(tc.curve.String(), func(t *testing.T) {
+			censusTree, err := census.NewCensusIMT(nil, tc.hasher)
+			if err != nil {
+				t.Fatalf("Failed to create census: %v", err)
+			}
+			for i := range 5 {
+				addr := common.BytesToAddress([]byte{byte(i + 1)})
+				if err := censusTree.Add(addr, big.NewInt(int64(i+1)*10)); err != nil {
+					t.Fatalf("Failed to add address %d: %v", i, err)
+				}
+			}
+
+			for _, i := range []int{0, 3, 4} {
+				proof, err := censusTree.GenerateProof(common.BytesToAddress([]byte{byte(i + 1)}))
+				if err != nil {
+					t.Fatalf("Failed to generate proof: %v", err)
+				}
+				witness := &censusPoseidon2Circuit{
+					Root:      proof.Root,
+					Address:   proof.Address.Big(),
+					Weight:    proof.Weight,
+					PathBits:  proof.PathBits,
+					LeafIndex: proof.AddressIndex,
+					Siblings:  CensusProofToMerkleProof(proof).Siblings,
+				}
+				assert := test.NewAssert(t)
+				assert.SolvingSucceeded(&censusPoseidon2Circuit{}, witness, test.WithCurves(tc.curve), test.WithBackends(backend.GROTH16))
+
+				// a proof against another root must fail
+				witness.Root = big.NewInt(1)
+				assert.SolvingFailed(&censusPoseidon2Circuit{}, witness, test.WithCurves(tc.curve), test.WithBackends(backend.GROTH16))
+			}
+		})
+	}
+}
+
+func TestVerifyCensusProof_Poseidon2Constraints(t *testing.T) {
+	poseidon, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &censusProofCircuit{})
+	if err != nil {
+		t.Fatalf("Failed to compile poseidon circuit: %v", err)
+	}
+	poseidon2, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &censusPoseidon2Circuit{})
+	if err != nil {
+		t.Fatalf("Failed to compile poseidon2 circuit: %v", err)
+	}
+	t.Logf("Constraints: poseidon %d, poseidon2 %d", poseidon.GetNbConstraints(), poseidon2.GetNbConstraints())
+	if poseidon2.GetNbConstraints() >= poseidon.GetNbConstraints() {
+		t.Fatalf("poseidon2 circuit has %d constraints, poseidon %d",
+			poseidon2.GetNbConstraints(), poseidon.GetNbConstraints())
+	}
+}
+
+// censusTaggedCircuit verifies census proofs of trees built with domain
+// separated Poseidon leaf and node hashers
+type censusTaggedCircuit censusProofCircuit
+
+func (circuit *censusTaggedCircuit) Define(api frontend.API) error {
+	proof := NewMerkleProof(api, circuit.Address, circuit.Weight,
+		circuit.PathBits, circuit.LeafIndex, circuit.Siblings)
+	isValid, err := proof.VerifyWithLeafHasher(api, circuit.Root,
+		PoseidonLeafHash(leanimt.LeafDomainTag), PoseidonNodeHash(leanimt.NodeDomainTag))
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(isValid, 1)
+	return nil
+}
+
+func TestVerifyCensusProof_LeafHasher(t *testing.T) {
+	censusTree, err := census.NewCensusIMT(nil, leanimt.PoseidonNodeHasher(leanimt.NodeDomainTag),
+		census.WithLeafHasher(leanimt.PoseidonLeafHasher(leanimt.LeafDomainTag)))
+	if err != nil {
+		t.Fatalf("Failed to create census: %v", err)
+	}
+	for i := range 5 {
+		addr := common.BytesToAddress([]byte{byte(i + 1)})
+		if err := censusTree.Add(addr, big.NewInt(int64(i+1)*10)); err != nil {
+			t.Fatalf("Failed to add address %d: %v", i, err)
+		}
+	}
+
+	for _, i := range []int{0, 4} {
+		proof, err := censusTree.GenerateProof(common.BytesToAddress([]byte{byte(i + 1)}))
+		if err != nil {
+			t.Fatalf("Failed to generate proof: %v", err)
+		}
+		witness := &censusTaggedCircuit{
+			Root:      proof.Root,
+			Address:   proof.Address.Big(),
+			Weight:    proof.Weight,
+			PathBits:  proof.PathBits,
+			LeafIndex: proof.AddressIndex,
+			Siblings:  CensusProofToMerkleProof(proof).Siblings,
+		}
+		assert := test.NewAssert(t)
+		assert.SolvingSucceeded(&censusTaggedCircuit{}, witness, test.WithCurves(ecc.BN254), test.WithBackends(backend.GROTH16))
+
+		// the untagged verifier must reject it
+		plain := censusProofCircuit(*witness)
+		assert.SolvingFailed(&censusProofCircuit{}, &plain, test.WithCurves(ecc.BN254), test.WithBackends(backend.GROTH16))
+	}
+}
@@ -0,0 +1,51 @@
+package circuit
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/permutation/poseidon2"
+	"github.com/vocdoni/gnark-crypto-primitives/hash/native/bn254/poseidon"
+)
+
+// Hasher hashes two tree nodes in-circuit. It must match the native hasher
+// the tree was built with.
+type Hasher func(api frontend.API, left, right frontend.Variable) (frontend.Variable, error)
+
+// LeafHasher hashes a leaf in-circuit before its proof path is walked. It
+// must match the leanimt.LeafHasher of the tree.
+type LeafHasher func(api frontend.API, leaf frontend.Variable) (frontend.Variable, error)
+
+// PoseidonHash is the in-circuit counterpart of leanimt.PoseidonHasher. It is
+// the hasher used by MerkleProof.Verify.
+func PoseidonHash(api frontend.API, left, right frontend.Variable) (frontend.Variable, error) {
+	return poseidon.Hash(api, left, right)
+}
+
+// Poseidon2Hash is the in-circuit counterpart of leanimt.Poseidon2Hasher on
+// BN254 and leanimt.Poseidon2BLS12377Hasher on BLS12-377, selected by the
+// field the circuit is compiled over. It needs considerably fewer
+// constraints than PoseidonHash.
+func Poseidon2Hash(api frontend.API, left, right frontend.Variable) (frontend.Variable, error) {
+	perm, err := poseidon2.NewPoseidon2(api)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create poseidon2 permutation: %w", err)
+	}
+	return perm.Compress(left, right), nil
+}
+
+// PoseidonLeafHash returns the in-circuit counterpart of
+// leanimt.PoseidonLeafHasher(tag), computing Poseidon(tag, leaf).
+func PoseidonLeafHash(tag int64) LeafHasher {
+	return func(api frontend.API, leaf frontend.Variable) (frontend.Variable, error) {
+		return poseidon.Hash(api, tag, leaf)
+	}
+}
+
+// PoseidonNodeHash returns the in-circuit counterpart of
+// leanimt.PoseidonNodeHasher(tag), computing Poseidon(tag, left, right).
+func PoseidonNodeHash(tag int64) Hasher {
+	return func(api frontend.API, left, right frontend.Variable) (frontend.Variable, error) {
+		return poseidon.Hash(api, tag, left, right)
+	}
+}
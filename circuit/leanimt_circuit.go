@@ -5,7 +5,6 @@ import (
 	"math/big"
 
 	"github.com/consensys/gnark/frontend"
-	"github.com/vocdoni/gnark-crypto-primitives/hash/native/bn254/poseidon"
 	"github.com/vocdoni/lean-imt-go/census"
 )
 
@@ -55,7 +54,8 @@ func NewMerkleProof(
 
 // Verify method verifies a Lean IMT Merkle proof. It uses the leaf, index and
 // siblings included in the MerkleProof struct to compute the root and compares
-// it with the provided root.
+// it with the provided root. Nodes are hashed with Poseidon, see VerifyWith
+// for trees built with other hashers.
 //
 // Parameters:
 //   - api: The frontend API for constraint operations
@@ -65,8 +65,27 @@ func NewMerkleProof(
 //   - frontend.Variable: A boolean variable (0 or 1) indicating proof validity.
 //   - error: Any error that occurred during compilation.
 func (p MerkleProof) Verify(api frontend.API, root frontend.Variable) (frontend.Variable, error) {
+	return p.VerifyWith(api, root, PoseidonHash)
+}
+
+// VerifyWith is like Verify, with the nodes hashed by hash. For trees built
+// with leanimt.Poseidon2Hasher, use Poseidon2Hash.
+func (p MerkleProof) VerifyWith(api frontend.API, root frontend.Variable, hash Hasher) (frontend.Variable, error) {
+	return p.VerifyWithLeafHasher(api, root, nil, hash)
+}
+
+// VerifyWithLeafHasher verifies a proof of a tree built with
+// leanimt.WithLeafHasher: the leaf is hashed with leafHash before walking up
+// the siblings with hash. A nil leafHash verifies as VerifyWith.
+func (p MerkleProof) VerifyWithLeafHasher(api frontend.API, root frontend.Variable, leafHash LeafHasher, hash Hasher) (frontend.Variable, error) {
 	// Initialize the current node with the leaf value
 	currentNode := p.Leaf
+	if leafHash != nil {
+		var err error
+		if currentNode, err = leafHash(api, p.Leaf); err != nil {
+			return frontend.Variable(0), fmt.Errorf("failed to hash leaf: %w", err)
+		}
+	}
 	// If no siblings, the leaf should equal the root (single-node tree)
 	if len(p.Siblings) == 0 {
 		isEqual := api.IsZero(api.Sub(currentNode, root))
@@ -84,8 +103,8 @@ func (p MerkleProof) Verify(api frontend.API, root frontend.Variable) (frontend.
 		// Compute hash based on position
 		leftInput := api.Select(bit, sibling, currentNode)
 		rightInput := api.Select(bit, currentNode, sibling)
-		// Hash the two inputs using Poseidon
-		hashedValue, err := poseidon.Hash(api, leftInput, rightInput)
+		// Hash the two inputs
+		hashedValue, err := hash(api, leftInput, rightInput)
 		if err != nil {
 			return frontend.Variable(0), fmt.Errorf("failed to hash nodes: %w", err)
 		}
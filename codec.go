@@ -0,0 +1,65 @@
+package leanimt
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errTruncated is returned when decoding runs out of input.
+var errTruncated = errors.New("truncated encoding")
+
+// appendUvarint appends x to buf as an unsigned varint.
+func appendUvarint(buf []byte, x uint64) []byte {
+	return binary.AppendUvarint(buf, x)
+}
+
+// appendValue encodes v with encoder and appends it to buf prefixed by its
+// length.
+func appendValue[N any](buf []byte, v N, encoder func(N) ([]byte, error)) ([]byte, error) {
+	b, err := encoder(v)
+	if err != nil {
+		return nil, err
+	}
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...), nil
+}
+
+// byteReader reads the values written by appendUvarint and appendValue.
+type byteReader struct {
+	data []byte
+}
+
+// uvarint reads an unsigned varint.
+func (r *byteReader) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.data = r.data[n:]
+	return x, nil
+}
+
+// count reads a varint used as a number of elements. Each element takes at
+// least one byte, which bounds the allocations done for malformed input.
+func (r *byteReader) count() (int, error) {
+	x, err := r.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if x > uint64(len(r.data)) {
+		return 0, errTruncated
+	}
+	return int(x), nil
+}
+
+// readValue reads a length-prefixed value and decodes it with decoder.
+func readValue[N any](r *byteReader, decoder func([]byte) (N, error)) (N, error) {
+	var zero N
+	n, err := r.count()
+	if err != nil {
+		return zero, err
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return decoder(b)
+}
@@ -0,0 +1,166 @@
+package leanimt
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math/big"
+	"os"
+	"testing"
+)
+
+// The conformance vectors are produced by the zk-kit TypeScript LeanIMT with
+// poseidon-lite. Refreshing them requires Node.js and network access.
+//go:generate sh -c "cd testdata/conformance/generate && npm install --no-save && node generate.mjs"
+
+const conformanceVectors = "testdata/conformance/vectors.json"
+
+type conformanceCase struct {
+	Name    string   `json:"name"`
+	Leaves  []string `json:"leaves"`
+	Updates []struct {
+		Index int    `json:"index"`
+		Leaf  string `json:"leaf"`
+	} `json:"updates"`
+	Root   string            `json:"root"`
+	Depth  int               `json:"depth"`
+	Export string            `json:"export"`
+	Proofs []json.RawMessage `json:"proofs"`
+}
+
+func TestConformanceZkKit(t *testing.T) {
+	data, err := os.ReadFile(conformanceVectors)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(conformanceVectors + " not found, run go generate to create it")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors struct {
+		Generator string            `json:"generator"`
+		Cases     []conformanceCase `json:"cases"`
+	}
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors.Cases) == 0 {
+		t.Fatal(conformanceVectors + " has no cases")
+	}
+	t.Logf("vectors generated by %s", vectors.Generator)
+
+	for _, c := range vectors.Cases {
+		t.Run(c.Name, func(t *testing.T) {
+			checkConformanceCase(t, c)
+		})
+	}
+}
+
+func checkConformanceCase(t *testing.T, c conformanceCase) {
+	leaves := make([]*big.Int, len(c.Leaves))
+	for i, s := range c.Leaves {
+		leaves[i] = parseDecimal(t, s)
+	}
+	batch, _ := New(PoseidonHasher, BigIntEqual, nil, nil, nil)
+	if err := batch.InsertMany(leaves); err != nil {
+		t.Fatal(err)
+	}
+	single, _ := New(PoseidonHasher, BigIntEqual, nil, nil, nil)
+	for _, leaf := range leaves {
+		single.Insert(leaf)
+	}
+	for _, tree := range []*LeanIMT[*big.Int]{batch, single} {
+		for _, u := range c.Updates {
+			if err := tree.Update(u.Index, parseDecimal(t, u.Leaf)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		root, _ := tree.Root()
+		if root.String() != c.Root {
+			t.Fatalf("root %s, want %s", root, c.Root)
+		}
+		if tree.Depth() != c.Depth {
+			t.Fatalf("depth %d, want %d", tree.Depth(), c.Depth)
+		}
+	}
+
+	// zk-kit stringifies bigints while Export writes JSON numbers, so the
+	// exports are compared value by value
+	exported, err := batch.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := exportValues(t, exported), exportValues(t, c.Export)
+	if len(got) != len(want) {
+		t.Fatalf("export has %d levels, want %d", len(got), len(want))
+	}
+	for level := range want {
+		if len(got[level]) != len(want[level]) {
+			t.Fatalf("export level %d has %d nodes, want %d", level, len(got[level]), len(want[level]))
+		}
+		for i := range want[level] {
+			if got[level][i].Cmp(want[level][i]) != 0 {
+				t.Fatalf("export node (%d, %d) mismatch", level, i)
+			}
+		}
+	}
+
+	for _, raw := range c.Proofs {
+		var expected MerkleProof[*big.Int]
+		if err := json.Unmarshal(raw, &expected); err != nil {
+			t.Fatal(err)
+		}
+		var position struct {
+			LeafIndex int `json:"leafIndex"`
+		}
+		if err := json.Unmarshal(raw, &position); err != nil {
+			t.Fatal(err)
+		}
+		proof, err := batch.GenerateProof(position.LeafIndex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Root.Cmp(expected.Root) != 0 || proof.Leaf.Cmp(expected.Leaf) != 0 ||
+			proof.PathBits != expected.PathBits || len(proof.Siblings) != len(expected.Siblings) {
+			t.Fatalf("proof %d mismatch", position.LeafIndex)
+		}
+		for i := range proof.Siblings {
+			if proof.Siblings[i].Cmp(expected.Siblings[i]) != 0 {
+				t.Fatalf("proof %d sibling %d mismatch", position.LeafIndex, i)
+			}
+		}
+		if !batch.VerifyProof(expected) {
+			t.Fatalf("zk-kit proof %d rejected", position.LeafIndex)
+		}
+	}
+}
+
+func parseDecimal(t *testing.T, s string) *big.Int {
+	t.Helper()
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		t.Fatalf("invalid decimal %q", s)
+	}
+	return n
+}
+
+// exportValues parses an exported node matrix whose values are JSON numbers
+// or decimal strings.
+func exportValues(t *testing.T, export string) [][]*big.Int {
+	t.Helper()
+	var raw [][]json.RawMessage
+	if err := json.Unmarshal([]byte(export), &raw); err != nil {
+		t.Fatal(err)
+	}
+	out := make([][]*big.Int, len(raw))
+	for level := range raw {
+		out[level] = make([]*big.Int, len(raw[level]))
+		for i, v := range raw[level] {
+			n, err := unmarshalValue[*big.Int](v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out[level][i] = n
+		}
+	}
+	return out
+}
@@ -0,0 +1,165 @@
+package leanimt
+
+import (
+	"errors"
+	"math/bits"
+	"reflect"
+)
+
+// The root of a LeanIMT with n leaves is the RFC 9162 Merkle tree hash of
+// its leaves, without the leaf and node prefixes: the left subtree holds the
+// largest power of two strictly smaller than n leaves, and a missing right
+// sibling promotes the left child unchanged. Consistency proofs therefore use
+// the Certificate Transparency algorithm.
+
+// GenerateConsistencyProof builds a proof that the first oldSize leaves of
+// the tree, as it had newSize leaves, are the leaves of the tree when it had
+// oldSize leaves. newSize must not exceed the current size. The proof is
+// computed from the current leaves, so it only verifies against a cached old
+// root if those leaves were not updated since.
+func (t *LeanIMT[N]) GenerateConsistencyProof(oldSize, newSize int) ([]N, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return generateConsistencyProof(len(t.nodes[0]), matrixNode(t.nodes), t.hash, oldSize, newSize)
+}
+
+// GenerateConsistencyProof builds a consistency proof between two sizes not
+// larger than the snapshot size.
+func (s *Snapshot[N]) GenerateConsistencyProof(oldSize, newSize int) ([]N, error) {
+	return generateConsistencyProof(len(s.nodes[0]), matrixNode(s.nodes), s.hash, oldSize, newSize)
+}
+
+// GenerateConsistencyProof builds a consistency proof between two sizes not
+// larger than the current size.
+func (t *DiskLeanIMT[N]) GenerateConsistencyProof(oldSize, newSize int) ([]N, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return generateConsistencyProof(t.size, t.node, t.hash, oldSize, newSize)
+}
+
+// generateConsistencyProof implements PROOF(m, D[n]) from RFC 9162 for a tree
+// with size leaves whose nodes are read through node.
+func generateConsistencyProof[N any](size int, node func(level, index int) (N, error), hash Hasher[N], oldSize, newSize int) ([]N, error) {
+	if oldSize <= 0 || oldSize > newSize {
+		return nil, errors.New("invalid consistency range " + intToString(oldSize) + ".." + intToString(newSize))
+	}
+	if newSize > size {
+		return nil, errors.New("size " + intToString(newSize) + " exceeds the tree size " + intToString(size))
+	}
+	r := subtreeReader[N]{size: size, node: node, hash: hash}
+	return r.subproof(oldSize, 0, newSize, true, nil)
+}
+
+// subtreeReader computes roots of leaf ranges from the stored nodes.
+type subtreeReader[N any] struct {
+	size int
+	node func(level, index int) (N, error)
+	hash Hasher[N]
+}
+
+// subproof implements SUBPROOF(m, D[start:end], complete) from RFC 9162,
+// appending the proof nodes to proof.
+func (r *subtreeReader[N]) subproof(m, start, end int, complete bool, proof []N) ([]N, error) {
+	n := end - start
+	if m == n {
+		if complete {
+			return proof, nil
+		}
+		root, err := r.root(start, end)
+		if err != nil {
+			return nil, err
+		}
+		return append(proof, root), nil
+	}
+
+	k := splitPoint(n)
+	var err error
+	var sibling N
+	if m <= k {
+		if proof, err = r.subproof(m, start, start+k, complete, proof); err != nil {
+			return nil, err
+		}
+		sibling, err = r.root(start+k, end)
+	} else {
+		if proof, err = r.subproof(m-k, start+k, end, false, proof); err != nil {
+			return nil, err
+		}
+		sibling, err = r.root(start, start+k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, sibling), nil
+}
+
+// root returns the root of the leaves [start, end). Ranges that match a
+// stored node are read directly; the others are split as in RFC 9162.
+func (r *subtreeReader[N]) root(start, end int) (N, error) {
+	n := end - start
+	level := ceilLog2(n)
+	if start&(1<<level-1) == 0 && (n == 1<<level || end == r.size) {
+		return r.node(level, start>>level)
+	}
+	k := splitPoint(n)
+	left, err := r.root(start, start+k)
+	if err != nil {
+		return left, err
+	}
+	right, err := r.root(start+k, end)
+	if err != nil {
+		return right, err
+	}
+	return r.hash(left, right), nil
+}
+
+// splitPoint returns the largest power of two strictly smaller than n > 1.
+func splitPoint(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// VerifyConsistencyProof checks that the tree with root newRoot and newSize
+// leaves is an append-only extension of the tree with root oldRoot and
+// oldSize leaves, following the RFC 9162 verification algorithm.
+func VerifyConsistencyProof[N any](oldRoot, newRoot N, oldSize, newSize int, proof []N, hash Hasher[N], eq Equal[N]) bool {
+	if hash == nil || oldSize <= 0 || oldSize > newSize {
+		return false
+	}
+	if eq == nil {
+		eq = func(a, b N) bool { return reflect.DeepEqual(a, b) }
+	}
+	if oldSize == newSize {
+		return len(proof) == 0 && eq(oldRoot, newRoot)
+	}
+	if oldSize&(oldSize-1) == 0 {
+		// the old tree is a complete subtree, its root is the first node
+		proof = append([]N{oldRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = hash(c, fr)
+			sr = hash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = hash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && eq(fr, oldRoot) && eq(sr, newRoot)
+}
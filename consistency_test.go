@@ -0,0 +1,72 @@
+package leanimt
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestConsistencyProof(t *testing.T) {
+	const maxSize = 40
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	roots := []*big.Int{nil}
+	for i := range maxSize {
+		tree.Insert(bigInt(int64(i + 1)))
+		r, _ := tree.Root()
+		roots = append(roots, r)
+	}
+	snap := tree.Snapshot()
+
+	for newSize := 1; newSize <= maxSize; newSize++ {
+		for oldSize := 1; oldSize <= newSize; oldSize++ {
+			proof, err := snap.GenerateConsistencyProof(oldSize, newSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyConsistencyProof(roots[oldSize], roots[newSize], oldSize, newSize, proof, bigIntHasher, BigIntEqual) {
+				t.Fatalf("valid proof %d -> %d rejected", oldSize, newSize)
+			}
+			if oldSize == newSize {
+				continue
+			}
+			if oldSize > 1 && VerifyConsistencyProof(roots[oldSize-1], roots[newSize], oldSize, newSize, proof, bigIntHasher, BigIntEqual) {
+				t.Fatalf("proof %d -> %d accepted a wrong old root", oldSize, newSize)
+			}
+			if VerifyConsistencyProof(roots[oldSize], roots[newSize], oldSize, newSize, proof[:len(proof)-1], bigIntHasher, BigIntEqual) {
+				t.Fatalf("truncated proof %d -> %d accepted", oldSize, newSize)
+			}
+		}
+	}
+}
+
+func TestConsistencyProofAfterUpdate(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	for i := range 6 {
+		tree.Insert(bigInt(int64(i)))
+	}
+	oldRoot, _ := tree.Root()
+	for i := range 7 {
+		tree.Insert(bigInt(int64(i + 100)))
+	}
+
+	proof, err := tree.GenerateConsistencyProof(6, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRoot, _ := tree.Root()
+	if !VerifyConsistencyProof(oldRoot, newRoot, 6, 13, proof, bigIntHasher, BigIntEqual) {
+		t.Fatal("append-only extension rejected")
+	}
+
+	// rewriting an old leaf breaks consistency with the cached root
+	if err := tree.Update(2, bigInt(42)); err != nil {
+		t.Fatal(err)
+	}
+	proof, _ = tree.GenerateConsistencyProof(6, 13)
+	newRoot, _ = tree.Root()
+	if VerifyConsistencyProof(oldRoot, newRoot, 6, 13, proof, bigIntHasher, BigIntEqual) {
+		t.Fatal("rewritten history accepted")
+	}
+	if _, err := tree.GenerateConsistencyProof(6, 14); err == nil {
+		t.Fatal("size beyond the tree should fail")
+	}
+}
@@ -0,0 +1,639 @@
+package leanimt
+
+import (
+	"container/list"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
+)
+
+// DefaultDiskCacheSize is the number of nodes kept in memory by a DiskLeanIMT
+// when no cache size is given.
+const DefaultDiskCacheSize = 1 << 20
+
+// DiskLeanIMT is a LeanIMT whose nodes live in a db.Database instead of
+// memory, for trees larger than RAM. Only an LRU cache of recently used nodes
+// and the nodes modified since the last Sync are kept in memory; since every
+// operation walks from a leaf to the root, the upper levels stay hot.
+//
+// Insert, Update and GenerateProof touch O(log n) nodes. The storage layout
+// is the one used by LeanIMT WithNodePersistence, so the same database can be
+// opened by both types.
+//
+// DiskLeanIMT is safe for concurrent use by multiple goroutines.
+type DiskLeanIMT[N any] struct {
+	mu       sync.RWMutex // protects all fields below
+	db       db.Database
+	hash     Hasher[N]
+	eq       Equal[N]
+	encoder  func(N) ([]byte, error)
+	decoder  func([]byte) (N, error)
+	hasherID string // registered name of hash, stored in meta:hasher ("" if unregistered)
+	size     int
+	cache    *nodeCache[N]
+	pending  map[nodePos]N // nodes modified since the last Sync
+	limit    int           // pending nodes that trigger an automatic Sync
+
+	undo map[nodePos]undoEntry[N] // state before the ongoing insertMany (nil if none)
+}
+
+// nodePos identifies a node by level and index.
+type nodePos struct {
+	level int
+	index int
+}
+
+// NewDisk creates a DiskLeanIMT backed by storage. Existing trees are opened
+// without loading them in memory; trees persisted without internal nodes
+// (plain LeanIMT Sync) get their nodes built and stored level by level.
+// cacheSize is the number of nodes kept in memory, DefaultDiskCacheSize if
+// it is zero or negative. As with New, trees built with a different hasher,
+// as recorded in meta:hasher, are refused with ErrHasherMismatch.
+func NewDisk[N any](hash Hasher[N], eq Equal[N], storage db.Database, encoder func(N) ([]byte, error), decoder func([]byte) (N, error), cacheSize int) (*DiskLeanIMT[N], error) {
+	if hash == nil {
+		return nil, errors.New("parameter 'hash' is not defined")
+	}
+	if storage == nil {
+		return nil, errors.New("parameter 'storage' is not defined")
+	}
+	if encoder == nil || decoder == nil {
+		return nil, errors.New("encoder and decoder functions are required when using persistent storage")
+	}
+	if cacheSize <= 0 {
+		cacheSize = DefaultDiskCacheSize
+	}
+	hasherID, err := resolveHasherID(hash, "")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &DiskLeanIMT[N]{
+		db:       storage,
+		hash:     hash,
+		eq:       eq,
+		encoder:  encoder,
+		decoder:  decoder,
+		hasherID: hasherID,
+		cache:    newNodeCache[N](cacheSize),
+		pending:  make(map[nodePos]N),
+		limit:    cacheSize,
+	}
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewDiskWithPebble is a wrapper around NewDisk. Creates a new DiskLeanIMT
+// using a persistent Pebble DB at the specified directory.
+func NewDiskWithPebble[N any](hash Hasher[N], eq Equal[N], encoder func(N) ([]byte, error), decoder func([]byte) (N, error), datadir string, cacheSize int) (*DiskLeanIMT[N], error) {
+	database, err := metadb.New(db.TypePebble, datadir)
+	if err != nil {
+		return nil, err
+	}
+	return NewDisk(hash, eq, database, encoder, decoder, cacheSize)
+}
+
+// open reads the tree metadata and makes sure internal nodes are stored.
+func (t *DiskLeanIMT[N]) open() error {
+	if err := checkStoredHasher(t.db, t.hasherID); err != nil {
+		return err
+	}
+	if err := TreeSchema.Upgrade(t.db); err != nil {
+		return err
+	}
+	sizeBytes, err := t.db.Get([]byte("meta:size"))
+	if err != nil {
+		if err == db.ErrKeyNotFound {
+			return nil // new tree
+		}
+		return err
+	}
+	t.size = decodeInt(sizeBytes)
+	if t.size == 0 {
+		return nil
+	}
+
+	marker, err := t.db.Get([]byte("meta:nodes"))
+	if err != nil && err != db.ErrKeyNotFound {
+		return err
+	}
+	if err == db.ErrKeyNotFound || decodeInt(marker) != t.size {
+		return t.buildNodes()
+	}
+
+	// check the stored root against the top of the stored tree
+	rootBytes, err := t.db.Get([]byte("meta:root"))
+	if err != nil {
+		return err
+	}
+	storedRoot, err := t.decoder(rootBytes)
+	if err != nil {
+		return err
+	}
+	root, err := t.node(t.depth(), 0)
+	if err != nil {
+		return err
+	}
+	if !t.equal(root, storedRoot) {
+		return ErrStoredRootMismatch
+	}
+	return nil
+}
+
+// buildNodes computes and stores every internal level from the stored
+// leaves, one level at a time, without holding the tree in memory.
+func (t *DiskLeanIMT[N]) buildNodes() error {
+	for level := 0; level < t.depth(); level++ {
+		parents := levelSize(t.size, level+1)
+		children := levelSize(t.size, level)
+		for i := 0; i < parents; i++ {
+			left, err := t.node(level, 2*i)
+			if err != nil {
+				return err
+			}
+			parent := left
+			if 2*i+1 < children {
+				right, err := t.node(level, 2*i+1)
+				if err != nil {
+					return err
+				}
+				parent = t.hash(left, right)
+			}
+			t.setNode(level+1, i, parent)
+			if len(t.pending) >= t.limit {
+				// the root is not known yet, store the nodes only
+				if err := t.writePending(false); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return t.flush()
+}
+
+// equal compares two values, using provided eq if present, otherwise reflect.DeepEqual.
+func (t *DiskLeanIMT[N]) equal(a, b N) bool {
+	if t.eq != nil {
+		return t.eq(a, b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// depth returns the dynamic depth for the current size.
+func (t *DiskLeanIMT[N]) depth() int {
+	return ceilLog2(t.size)
+}
+
+// nodeKey returns the storage key of a node; leaves use the LeanIMT keys.
+func (t *DiskLeanIMT[N]) nodeKey(level, index int) []byte {
+	if level == 0 {
+		return []byte("leaf:" + intToString(index))
+	}
+	return nodeKey(level, index)
+}
+
+// node returns the node at (level, index) from the pending writes, the
+// cache or the database, in that order.
+func (t *DiskLeanIMT[N]) node(level, index int) (N, error) {
+	pos := nodePos{level, index}
+	if v, ok := t.pending[pos]; ok {
+		return v, nil
+	}
+	if v, ok := t.cache.get(pos); ok {
+		return v, nil
+	}
+	var zero N
+	value, err := t.db.Get(t.nodeKey(level, index))
+	if err != nil {
+		return zero, err
+	}
+	v, err := t.decoder(value)
+	if err != nil {
+		return zero, err
+	}
+	t.cache.add(pos, v)
+	return v, nil
+}
+
+// setNode records a modified node, written to storage on the next Sync.
+func (t *DiskLeanIMT[N]) setNode(level, index int, v N) {
+	pos := nodePos{level, index}
+	if t.undo != nil {
+		if _, ok := t.undo[pos]; !ok {
+			old, pending := t.pending[pos]
+			t.undo[pos] = undoEntry[N]{old, pending}
+		}
+	}
+	t.cache.remove(pos)
+	t.pending[pos] = v
+}
+
+// undoEntry is the pending state of a node before an operation modified it.
+type undoEntry[N any] struct {
+	value   N
+	pending bool
+}
+
+// trackPending records the pending state of the nodes set from now on, until
+// t.undo is reset, and returns a function restoring it. Nodes dropped from
+// the cache by setNode are read again from storage.
+func (t *DiskLeanIMT[N]) trackPending() (undo func()) {
+	t.undo = make(map[nodePos]undoEntry[N])
+	return func() {
+		for pos, e := range t.undo {
+			if e.pending {
+				t.pending[pos] = e.value
+			} else {
+				delete(t.pending, pos)
+			}
+		}
+	}
+}
+
+// Depth returns the current dynamic depth (levels - 1).
+func (t *DiskLeanIMT[N]) Depth() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.depth()
+}
+
+// Size returns the number of leaves.
+func (t *DiskLeanIMT[N]) Size() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.size
+}
+
+// Root returns the root and a boolean indicating whether it exists.
+func (t *DiskLeanIMT[N]) Root() (N, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var zero N
+	if t.size == 0 {
+		return zero, false
+	}
+	root, err := t.node(t.depth(), 0)
+	if err != nil {
+		return zero, false
+	}
+	return root, true
+}
+
+// Leaf returns the leaf at index.
+func (t *DiskLeanIMT[N]) Leaf(index int) (N, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if index < 0 || index >= t.size {
+		var zero N
+		return zero, errLeafOutOfRange(index)
+	}
+	return t.node(0, index)
+}
+
+// Insert inserts a single leaf at the end, updating path to root bottom-up.
+// The siblings on the path are read before modifying the tree, so a storage
+// error leaves it unchanged.
+func (t *DiskLeanIMT[N]) Insert(leaf N) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index := t.size
+	depth := ceilLog2(t.size + 1)
+	siblings := make([]N, depth)
+	for level, i := 0, index; level < depth; level, i = level+1, i>>1 {
+		if (i & 1) == 1 {
+			sibling, err := t.node(level, i-1)
+			if err != nil {
+				return -1, err
+			}
+			siblings[level] = sibling
+		}
+	}
+
+	t.size++
+	node := leaf
+	t.setNode(0, index, node)
+	for level := range depth {
+		if level > 0 {
+			t.setNode(level, index, node)
+		}
+		if (index & 1) == 1 {
+			node = t.hash(siblings[level], node)
+		}
+		index >>= 1
+	}
+	t.setNode(depth, 0, node)
+
+	return t.size - 1, t.maybeFlush()
+}
+
+// InsertMany inserts m leaves in batch (more efficient than m x Insert).
+// Large batches may be committed to storage in several steps.
+func (t *DiskLeanIMT[N]) InsertMany(leaves []N) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(leaves) == 0 {
+		return errors.New("there are no leaves to add")
+	}
+
+	// insert in chunks bounded by the pending limit, so memory stays bounded
+	chunk := max(t.limit/2, 1)
+	for start := 0; start < len(leaves); start += chunk {
+		if err := t.insertMany(leaves[start:min(start+chunk, len(leaves))]); err != nil {
+			return err
+		}
+		if err := t.maybeFlush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertMany appends leaves and recomputes the affected parents per level.
+// Parents are computed from nodes set by the same call, so on a storage error
+// the modified nodes and the size are rolled back instead.
+func (t *DiskLeanIMT[N]) insertMany(leaves []N) (err error) {
+	size, undo := t.size, t.trackPending()
+	defer func() {
+		if err != nil {
+			t.size = size
+			undo()
+		}
+		t.undo = nil
+	}()
+
+	startIndex := t.size >> 1
+	for i, leaf := range leaves {
+		t.setNode(0, t.size+i, leaf)
+	}
+	t.size += len(leaves)
+
+	for level := 0; level < t.depth(); level++ {
+		children := levelSize(t.size, level)
+		numNodes := (children + 1) / 2
+		for index := startIndex; index < numNodes; index++ {
+			li := index * 2
+			ri := li + 1
+			left, err := t.node(level, li)
+			if err != nil {
+				return err
+			}
+			parent := left
+			if ri < children {
+				right, err := t.node(level, ri)
+				if err != nil {
+					return err
+				}
+				parent = t.hash(left, right)
+			}
+			t.setNode(level+1, index, parent)
+		}
+		startIndex >>= 1
+	}
+	return nil
+}
+
+// Update replaces the leaf at index with newLeaf and updates path to root.
+// The siblings on the path are read before modifying the tree, so a storage
+// error leaves it unchanged.
+func (t *DiskLeanIMT[N]) Update(index int, newLeaf N) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if index < 0 || index >= t.size {
+		return errors.New("index is out of range")
+	}
+
+	depth := t.depth()
+	siblings := make([]N, depth)
+	hasSibling := make([]bool, depth)
+	for level, i := 0, index; level < depth; level, i = level+1, i>>1 {
+		sibling := i ^ 1
+		if sibling >= levelSize(t.size, level) {
+			continue
+		}
+		v, err := t.node(level, sibling)
+		if err != nil {
+			return err
+		}
+		siblings[level], hasSibling[level] = v, true
+	}
+
+	node := newLeaf
+	t.setNode(0, index, node)
+	for level := 0; level < depth; level++ {
+		if level > 0 {
+			t.setNode(level, index, node)
+		}
+		if (index & 1) == 1 {
+			node = t.hash(siblings[level], node)
+		} else if hasSibling[level] {
+			node = t.hash(node, siblings[level])
+		}
+		index >>= 1
+	}
+	t.setNode(depth, 0, node)
+
+	return t.maybeFlush()
+}
+
+// GenerateProof builds a LeanIMT proof for the leaf at index.
+func (t *DiskLeanIMT[N]) GenerateProof(index int) (MerkleProof[N], error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var empty MerkleProof[N]
+	if index < 0 || index >= t.size {
+		return empty, errLeafOutOfRange(index)
+	}
+	leaf, err := t.node(0, index)
+	if err != nil {
+		return empty, err
+	}
+	proof := MerkleProof[N]{Leaf: leaf, LeafIndex: uint64(index)}
+
+	depth := t.depth()
+	for level := 0; level < depth; level++ {
+		sibling := index ^ 1
+		if sibling < levelSize(t.size, level) {
+			v, err := t.node(level, sibling)
+			if err != nil {
+				return empty, err
+			}
+			if index&1 == 1 {
+				proof.PathBits |= 1 << uint(len(proof.Siblings))
+			}
+			proof.Siblings = append(proof.Siblings, v)
+		}
+		index >>= 1
+	}
+	if proof.Root, err = t.node(depth, 0); err != nil {
+		return empty, err
+	}
+	return proof, nil
+}
+
+// VerifyProof verifies a proof against the current tree hash function.
+func (t *DiskLeanIMT[N]) VerifyProof(proof MerkleProof[N]) bool {
+	return VerifyProofWith(proof, t.hash, t.eq)
+}
+
+// maybeFlush syncs the tree when too many modified nodes are pending.
+func (t *DiskLeanIMT[N]) maybeFlush() error {
+	if len(t.pending) < t.limit {
+		return nil
+	}
+	return t.flush()
+}
+
+// Sync persists the modified nodes and metadata atomically.
+func (t *DiskLeanIMT[N]) Sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.flush()
+}
+
+// flush writes the pending nodes and metadata in a single transaction and
+// moves the written nodes to the cache.
+func (t *DiskLeanIMT[N]) flush() error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	return t.writePending(true)
+}
+
+// writePending stores the pending nodes in a single transaction and moves
+// them to the cache. If withMeta is set the root, size and node marker are
+// stored too, so the stored tree is complete.
+func (t *DiskLeanIMT[N]) writePending(withMeta bool) error {
+	tx := t.db.WriteTx()
+	defer tx.Discard()
+
+	for pos, v := range t.pending {
+		value, err := t.encoder(v)
+		if err != nil {
+			return err
+		}
+		if err := tx.Set(t.nodeKey(pos.level, pos.index), value); err != nil {
+			return err
+		}
+	}
+	if !withMeta {
+		return t.commitPending(tx)
+	}
+	if t.size > 0 {
+		root, err := t.node(t.depth(), 0)
+		if err != nil {
+			return err
+		}
+		value, err := t.encoder(root)
+		if err != nil {
+			return err
+		}
+		if err := tx.Set([]byte("meta:root"), value); err != nil {
+			return err
+		}
+	}
+	if err := tx.Set([]byte("meta:nodes"), encodeInt(t.size)); err != nil {
+		return err
+	}
+	if err := tx.Set([]byte("meta:size"), encodeInt(t.size)); err != nil {
+		return err
+	}
+	if err := tx.Set([]byte("meta:version"), encodeInt(SchemaVersion)); err != nil {
+		return err
+	}
+	if t.hasherID != "" {
+		if err := tx.Set([]byte("meta:hasher"), []byte(t.hasherID)); err != nil {
+			return err
+		}
+	}
+	return t.commitPending(tx)
+}
+
+// commitPending commits tx and moves the pending nodes to the cache.
+func (t *DiskLeanIMT[N]) commitPending(tx db.WriteTx) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for pos, v := range t.pending {
+		t.cache.add(pos, v)
+	}
+	t.pending = make(map[nodePos]N)
+	return nil
+}
+
+// Close ensures all changes are synced and closes the database connection.
+func (t *DiskLeanIMT[N]) Close() error {
+	if err := t.Sync(); err != nil {
+		return err
+	}
+	return t.db.Close()
+}
+
+// nodeCache is a fixed-capacity LRU cache of clean nodes. It has its own
+// lock so readers holding the tree read lock can populate it.
+type nodeCache[N any] struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recently used
+	items    map[nodePos]*list.Element
+}
+
+// cacheEntry is the value stored in the nodeCache list.
+type cacheEntry[N any] struct {
+	pos   nodePos
+	value N
+}
+
+// newNodeCache creates an empty cache holding up to capacity nodes.
+func newNodeCache[N any](capacity int) *nodeCache[N] {
+	return &nodeCache[N]{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[nodePos]*list.Element),
+	}
+}
+
+// get returns a cached node and marks it as recently used.
+func (c *nodeCache[N]) get(pos nodePos) (N, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[pos]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(cacheEntry[N]).value, true
+	}
+	var zero N
+	return zero, false
+}
+
+// add caches a node, evicting the least recently used one when full.
+func (c *nodeCache[N]) add(pos nodePos, v N) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[pos]; ok {
+		e.Value = cacheEntry[N]{pos, v}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[pos] = c.order.PushFront(cacheEntry[N]{pos, v})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(cacheEntry[N]).pos)
+	}
+}
+
+// remove drops a node from the cache.
+func (c *nodeCache[N]) remove(pos nodePos) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[pos]; ok {
+		c.order.Remove(e)
+		delete(c.items, pos)
+	}
+}
@@ -0,0 +1,212 @@
+package leanimt
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
+)
+
+func TestDiskMatchesInMemory(t *testing.T) {
+	tempDir := createTempDir(t)
+
+	// a tiny cache forces evictions and automatic flushes
+	disk, err := NewDiskWithPebble(bigIntHasher, BigIntEqual, bigIntEncoder, bigIntDecoder, tempDir, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+
+	check := func(step string) {
+		t.Helper()
+		r1, ok1 := disk.Root()
+		r2, ok2 := mem.Root()
+		if ok1 != ok2 || (ok1 && r1.Cmp(r2) != 0) {
+			t.Fatalf("%s: root mismatch", step)
+		}
+		if disk.Size() != mem.Size() || disk.Depth() != mem.Depth() {
+			t.Fatalf("%s: size or depth mismatch", step)
+		}
+	}
+
+	for i := range 13 {
+		if _, err := disk.Insert(bigInt(int64(i))); err != nil {
+			t.Fatal(err)
+		}
+		mem.Insert(bigInt(int64(i)))
+		check("insert")
+	}
+	batch := make([]*big.Int, 50)
+	for i := range batch {
+		batch[i] = bigInt(int64(100 + i))
+	}
+	if err := disk.InsertMany(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.InsertMany(batch); err != nil {
+		t.Fatal(err)
+	}
+	check("insertMany")
+	for _, i := range []int{0, 7, 12, 62} {
+		if err := disk.Update(i, bigInt(int64(1000+i))); err != nil {
+			t.Fatal(err)
+		}
+		if err := mem.Update(i, bigInt(int64(1000+i))); err != nil {
+			t.Fatal(err)
+		}
+		check("update")
+	}
+
+	for i := range mem.Size() {
+		p1, err := disk.GenerateProof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p2, _ := mem.GenerateProof(i)
+		if p1.PathBits != p2.PathBits || len(p1.Siblings) != len(p2.Siblings) || !disk.VerifyProof(p1) {
+			t.Fatalf("proof %d mismatch", i)
+		}
+	}
+	multi, err := disk.GenerateMultiProof([]int{3, 4, 40, 62})
+	if err != nil || !mem.VerifyMultiProof(multi) {
+		t.Fatalf("disk multi-proof rejected: %v", err)
+	}
+	if err := disk.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the database can be reopened by both tree types
+	reopened, err := NewDiskWithPebble(bigIntHasher, BigIntEqual, bigIntEncoder, bigIntDecoder, tempDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1, _ := reopened.Root()
+	r2, _ := mem.Root()
+	if r1.Cmp(r2) != 0 {
+		t.Fatal("root mismatch after reopening")
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewWithPebble(bigIntHasher, BigIntEqual, bigIntEncoder, bigIntDecoder, tempDir, WithNodePersistence[*big.Int]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tree.Close() }()
+	r3, _ := tree.Root()
+	if r3.Cmp(r2) != 0 {
+		t.Fatal("root mismatch when opened as LeanIMT")
+	}
+}
+
+func TestDiskBuildsNodesFromLeaves(t *testing.T) {
+	tempDir := createTempDir(t)
+	database, err := metadb.New(db.TypePebble, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a LeanIMT without node persistence only stores leaves
+	tree, err := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := make([]*big.Int, 21)
+	for i := range leaves {
+		leaves[i] = bigInt(int64(i))
+	}
+	if err := tree.InsertMany(leaves); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := tree.Root()
+
+	disk, err := NewDisk(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = disk.Close() }()
+	got, _ := disk.Root()
+	if got.Cmp(want) != 0 {
+		t.Fatal("root mismatch after building nodes")
+	}
+	leaf, err := disk.Leaf(20)
+	if err != nil || leaf.Cmp(bigInt(20)) != 0 {
+		t.Fatalf("unexpected leaf %v: %v", leaf, err)
+	}
+}
+
+// failingDB wraps a database and fails reads while fail is set.
+type failingDB struct {
+	db.Database
+	fail bool
+}
+
+var errFailingRead = errors.New("read failed")
+
+func (f *failingDB) Get(key []byte) ([]byte, error) {
+	if f.fail {
+		return nil, errFailingRead
+	}
+	return f.Database.Get(key)
+}
+
+func TestDiskReadErrorsKeepTree(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := &failingDB{Database: database}
+	// a single cached node forces reads from storage
+	disk, err := NewDisk(bigIntHasher, BigIntEqual, failing, bigIntEncoder, bigIntDecoder, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	mem, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	leaves := manyLeaves(1, 11)
+	if err := disk.InsertMany(leaves); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.InsertMany(leaves); err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	failing.fail = true
+	if _, err := disk.Insert(bigInt(100)); !errors.Is(err, errFailingRead) {
+		t.Fatalf("expected read error, got %v", err)
+	}
+	if err := disk.Update(4, bigInt(100)); !errors.Is(err, errFailingRead) {
+		t.Fatalf("expected read error, got %v", err)
+	}
+	if err := disk.InsertMany(manyLeaves(100, 5)); !errors.Is(err, errFailingRead) {
+		t.Fatalf("expected read error, got %v", err)
+	}
+	failing.fail = false
+
+	// the failed operations left no trace
+	if disk.Size() != mem.Size() {
+		t.Fatalf("size=%d, want %d", disk.Size(), mem.Size())
+	}
+	for i := range mem.Size() {
+		if leaf, err := disk.Leaf(i); err != nil || leaf.Cmp(leaves[i]) != 0 {
+			t.Fatalf("leaf %d changed to %v (%v)", i, leaf, err)
+		}
+	}
+	if _, err := disk.Insert(bigInt(12)); err != nil {
+		t.Fatal(err)
+	}
+	mem.Insert(bigInt(12))
+	r1, _ := disk.Root()
+	r2, _ := mem.Root()
+	if r1.Cmp(r2) != 0 {
+		t.Fatal("root differs after recovering from read errors")
+	}
+}
@@ -0,0 +1,129 @@
+package leanimt
+
+import "slices"
+
+// LeafHasher maps a leaf to the node committed for it at level 0 of the tree.
+//
+// Without a leaf hasher, leaves are nodes: an internal node value can be
+// presented as a leaf together with the siblings above it, and its proof
+// verifies (a second-preimage attack). Hashing leaves into a separate domain
+// from internal nodes, for instance with different tags as PoseidonLeafHasher
+// and PoseidonNodeHasher do, rules this out.
+type LeafHasher[N any] func(leaf N) N
+
+// WithLeafHasher hashes every leaf with leafHash before committing it to the
+// tree. The tree keeps the leaves as inserted: Leaves, IndexOf, Export, the
+// persisted leaves and the Leaf of generated proofs are the original values,
+// while the root and proof siblings are computed over their hashes. Proofs
+// must be verified with VerifyProofWithLeafHasher, or with the tree's
+// VerifyProof.
+//
+// Changing the leaf hasher of a persisted tree changes its root. Sorted trees
+// don't support leaf hashers.
+func WithLeafHasher[N any](leafHash LeafHasher[N]) Option[N] {
+	return func(t *LeanIMT[N]) {
+		t.leafHash = leafHash
+	}
+}
+
+// values returns the leaves as inserted: the original leaves with a leaf
+// hasher, level 0 otherwise.
+func (t *LeanIMT[N]) values() []N {
+	if t.leafHash != nil {
+		return t.leaves
+	}
+	return t.nodes[0]
+}
+
+// setLeaf stores leaf at index, which may be the next free position, and
+// returns the node committed for it at level 0.
+func (t *LeanIMT[N]) setLeaf(index int, leaf N) N {
+	t.unshare(0, index)
+	node := leaf
+	if t.leafHash != nil {
+		node = t.leafHash(leaf)
+		ensureIndex(&t.leaves, index)
+		t.leaves[index] = leaf
+	}
+	ensureIndex(&t.nodes[0], index)
+	t.nodes[0][index] = node
+	return node
+}
+
+// appendLeaves appends leaves to level 0, hashing them with the leaf hasher
+// if any.
+func (t *LeanIMT[N]) appendLeaves(leaves []N) {
+	if t.leafHash == nil {
+		t.nodes[0] = append(t.nodes[0], leaves...)
+		return
+	}
+	t.leaves = append(t.leaves, leaves...)
+	t.nodes[0] = append(t.nodes[0], t.leafNodes(leaves)...)
+}
+
+// resetLeaves replaces the tree by a single level holding leaves, for the
+// caller to rebuild.
+func (t *LeanIMT[N]) resetLeaves(leaves []N) {
+	if t.leafHash == nil {
+		t.nodes = [][]N{leaves}
+		return
+	}
+	t.leaves = leaves
+	t.nodes = [][]N{t.leafNodes(leaves)}
+}
+
+// setNodes replaces the node matrix by nodes, whose level 0 holds the leaves
+// as inserted.
+func (t *LeanIMT[N]) setNodes(nodes [][]N) {
+	if t.leafHash != nil && len(nodes) > 0 {
+		t.leaves = nodes[0]
+		nodes[0] = t.leafNodes(t.leaves)
+	}
+	t.nodes = nodes
+}
+
+// leafNodes returns the level 0 nodes of leaves, hashed by t.workers
+// goroutines.
+func (t *LeanIMT[N]) leafNodes(leaves []N) []N {
+	nodes := make([]N, len(leaves))
+	t.parallel(0, len(leaves), func(from, to int) {
+		for i := from; i < to; i++ {
+			nodes[i] = t.leafHash(leaves[i])
+		}
+	})
+	return nodes
+}
+
+// withValues returns a copy of the nodes matrix with level 0 replaced by the
+// leaves as inserted, for export.
+func withValues[N any](nodes [][]N, values []N) [][]N {
+	cp := slices.Clone(nodes)
+	cp[0] = append(make([]N, 0, len(values)), values...)
+	return cp
+}
+
+// multiProofValues replaces the leaves of proof, read from level 0, by the
+// values at its indices.
+func multiProofValues[N any](proof MultiProof[N], values []N, err error) (MultiProof[N], error) {
+	if err != nil {
+		return proof, err
+	}
+	for i, index := range proof.Indices {
+		proof.Leaves[i] = values[index]
+	}
+	return proof, nil
+}
+
+// hashMultiProofLeaves returns a copy of proof with its leaves hashed by
+// leafHash, if not nil, so it can be verified against the tree nodes.
+func hashMultiProofLeaves[N any](proof MultiProof[N], leafHash LeafHasher[N]) MultiProof[N] {
+	if leafHash == nil {
+		return proof
+	}
+	leaves := make([]N, len(proof.Leaves))
+	for i, leaf := range proof.Leaves {
+		leaves[i] = leafHash(leaf)
+	}
+	proof.Leaves = leaves
+	return proof
+}
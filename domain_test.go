@@ -0,0 +1,183 @@
+package leanimt
+
+import (
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
+)
+
+// testLeafHash is a cheap leaf hasher for tests.
+func testLeafHash(leaf *big.Int) *big.Int {
+	return new(big.Int).Add(new(big.Int).Mul(leaf, bigInt(7)), bigInt(3))
+}
+
+// hashedTree builds a tree without leaf hasher over the hashes of leaves.
+func hashedTree(t *testing.T, leaves []*big.Int) *LeanIMT[*big.Int] {
+	t.Helper()
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	nodes := make([]*big.Int, len(leaves))
+	for i, leaf := range leaves {
+		nodes[i] = testLeafHash(leaf)
+	}
+	if err := tree.InsertMany(nodes); err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestLeafHasher(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil,
+		WithLeafHasher(testLeafHash), WithKeyIndex(BigIntKey))
+	tree.Insert(bigInt(1))
+	if root, _ := tree.Root(); root.Cmp(testLeafHash(bigInt(1))) != 0 {
+		t.Fatalf("single leaf root %s is not the leaf hash", root)
+	}
+	if err := tree.InsertMany(manyLeaves(2, 6)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Update(2, bigInt(30)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.UpdateMany([]int{0, 6}, []*big.Int{bigInt(10), bigInt(70)}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*big.Int{bigInt(10), bigInt(2), bigInt(30), bigInt(4), bigInt(5), bigInt(6), bigInt(70)}
+	if !slices.EqualFunc(tree.Leaves(), want, BigIntEqual) {
+		t.Fatalf("unexpected leaves %v", tree.Leaves())
+	}
+	if tree.IndexOf(bigInt(30)) != 2 || tree.Has(testLeafHash(bigInt(30))) {
+		t.Fatal("lookups must use the leaves as inserted")
+	}
+	root, _ := tree.Root()
+	if r, _ := hashedTree(t, want).Root(); r.Cmp(root) != 0 {
+		t.Fatal("root differs from the tree of leaf hashes")
+	}
+
+	for i := range tree.Size() {
+		proof, err := tree.GenerateProof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Leaf.Cmp(want[i]) != 0 {
+			t.Fatalf("proof %d has leaf %s", i, proof.Leaf)
+		}
+		if !tree.VerifyProof(proof) || !VerifyProofWithLeafHasher(proof, testLeafHash, bigIntHasher, BigIntEqual) {
+			t.Fatalf("proof %d rejected", i)
+		}
+		if VerifyProofWith(proof, bigIntHasher, BigIntEqual) {
+			t.Fatalf("proof %d accepted without the leaf hasher", i)
+		}
+	}
+
+	multi, err := tree.GenerateMultiProof([]int{1, 2, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(multi.Leaves, []*big.Int{bigInt(2), bigInt(30), bigInt(70)}, BigIntEqual) {
+		t.Fatalf("unexpected multi-proof leaves %v", multi.Leaves)
+	}
+	if !tree.VerifyMultiProof(multi) || VerifyMultiProofWith(multi, bigIntHasher, BigIntEqual) {
+		t.Fatal("multi-proof must only verify with the leaf hasher")
+	}
+}
+
+func TestLeafHasherSecondPreimage(t *testing.T) {
+	hash, leafHash := PoseidonNodeHasher(NodeDomainTag), PoseidonLeafHasher(LeafDomainTag)
+	plain, _ := New(PoseidonHasher, BigIntEqual, nil, nil, nil)
+	tagged, _ := New(hash, BigIntEqual, nil, nil, nil, WithLeafHasher(leafHash))
+	for _, tree := range []*LeanIMT[*big.Int]{plain, tagged} {
+		if err := tree.InsertMany(manyLeaves(1, 4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// present the parent of leaves 0 and 1 as a leaf of a two-leaf tree
+	forge := func(tree *LeanIMT[*big.Int]) MerkleProof[*big.Int] {
+		root, _ := tree.Root()
+		return MerkleProof[*big.Int]{
+			Root:     root,
+			Leaf:     tree.nodes[1][0],
+			Siblings: []*big.Int{tree.nodes[1][1]},
+		}
+	}
+	if !VerifyProofWith(forge(plain), PoseidonHasher, BigIntEqual) {
+		t.Fatal("internal node not accepted as a leaf without domain separation")
+	}
+	if tagged.VerifyProof(forge(tagged)) || VerifyProofWithLeafHasher(forge(tagged), leafHash, hash, BigIntEqual) {
+		t.Fatal("internal node accepted as a leaf with domain separation")
+	}
+	if PoseidonLeafHasher(NodeDomainTag)(bigInt(1)).Cmp(leafHash(bigInt(1))) == 0 {
+		t.Fatal("leaf tag ignored")
+	}
+}
+
+func TestLeafHasherSnapshotAndExport(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil, WithLeafHasher(testLeafHash))
+	if err := tree.InsertMany(manyLeaves(1, 4)); err != nil {
+		t.Fatal(err)
+	}
+	snap := tree.Snapshot()
+	if err := tree.Update(0, bigInt(100)); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(snap.Leaves(), manyLeaves(1, 4), BigIntEqual) {
+		t.Fatalf("snapshot leaves changed to %v", snap.Leaves())
+	}
+	proof, err := snap.GenerateProof(0)
+	if err != nil || proof.Leaf.Cmp(bigInt(1)) != 0 || !snap.VerifyProof(proof) {
+		t.Fatalf("unexpected snapshot proof %+v, %v", proof, err)
+	}
+
+	data, err := tree.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := Import(bigIntHasher, data, BigIntEqual, nil, WithLeafHasher(testLeafHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := tree.Root()
+	if r, _ := imported.Root(); r.Cmp(root) != 0 || !slices.EqualFunc(imported.Leaves(), tree.Leaves(), BigIntEqual) {
+		t.Fatal("imported tree differs")
+	}
+
+	if _, err := NewSorted(bigIntHasher, (*big.Int).Cmp, nil, nil, nil, WithLeafHasher(testLeafHash)); err == nil {
+		t.Fatal("sorted tree accepted a leaf hasher")
+	}
+}
+
+func TestLeafHasherPersistence(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	open := func() *LeanIMT[*big.Int] {
+		tree, err := New(bigIntHasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder,
+			WithLeafHasher(testLeafHash), WithNodePersistence[*big.Int]())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+	tree := open()
+	if err := tree.InsertMany(manyLeaves(1, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	root, _ := tree.Root()
+
+	loaded := open()
+	if r, _ := loaded.Root(); r.Cmp(root) != 0 {
+		t.Fatal("loaded root differs")
+	}
+	if !slices.EqualFunc(loaded.Leaves(), manyLeaves(1, 5), BigIntEqual) {
+		t.Fatalf("unexpected loaded leaves %v", loaded.Leaves())
+	}
+}
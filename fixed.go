@@ -0,0 +1,284 @@
+package leanimt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"sync"
+
+	fr_bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	mimc_bls12_377 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr/mimc"
+	fr_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	mimc_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// The hashers in this file work on fixed-size node types stored by value in
+// the tree, so hashing a node does not allocate. They produce the same roots
+// as their *big.Int counterparts for inputs in range: the Bytes32 hashers
+// match Keccak256Hasher, SHA256FixedHasher and Blake2bFixedHasher on 32-byte
+// big-endian values, the MiMC hashers match on reduced field elements.
+
+// errBytes32Length is returned when decoding a fixed-size node from a buffer
+// of the wrong length.
+var errBytes32Length = errors.New("fixed-size node must be 32 bytes")
+
+// SHA256Bytes32Hasher performs SHA-256 on the 64-byte concatenation of two
+// 32-byte nodes.
+//
+// Unlike SHA256Hasher, the inputs keep their leading zeros, so the result
+// matches Solidity's sha256(abi.encodePacked(a, b)) for bytes32 values.
+//
+// Parameters:
+//   - a: First input value
+//   - b: Second input value
+//
+// Returns: Hash result as [32]byte
+func SHA256Bytes32Hasher(a, b [32]byte) [32]byte {
+	var buf [64]byte
+	copy(buf[:32], a[:])
+	copy(buf[32:], b[:])
+	return sha256.Sum256(buf[:])
+}
+
+// Blake2bBytes32Hasher performs BLAKE2b-256 on the 64-byte concatenation of
+// two 32-byte nodes.
+//
+// Parameters:
+//   - a: First input value
+//   - b: Second input value
+//
+// Returns: Hash result as [32]byte
+func Blake2bBytes32Hasher(a, b [32]byte) [32]byte {
+	var buf [64]byte
+	copy(buf[:32], a[:])
+	copy(buf[32:], b[:])
+	return blake2b.Sum256(buf[:])
+}
+
+// Bytes32Equal is an equality function for [32]byte nodes.
+//
+// Parameters:
+//   - a: First value to compare
+//   - b: Second value to compare
+//
+// Returns: true if a equals b, false otherwise
+func Bytes32Equal(a, b [32]byte) bool {
+	return a == b
+}
+
+// Bytes32Key returns a lookup key for [32]byte nodes, suitable for the
+// WithKeyIndex option.
+//
+// Parameters:
+//   - n: The value to index
+//
+// Returns: The key as a string
+func Bytes32Key(n [32]byte) string {
+	return string(n[:])
+}
+
+// Bytes32Encoder encodes a [32]byte node as its 32 bytes.
+//
+// Parameters:
+//   - n: The value to encode
+//
+// Returns: Byte slice representation of the value
+func Bytes32Encoder(n [32]byte) ([]byte, error) {
+	return n[:], nil
+}
+
+// Bytes32Decoder decodes a [32]byte node.
+//
+// Parameters:
+//   - data: Byte slice to decode, which must be 32 bytes long
+//
+// Returns: Decoded value, or error if data has the wrong length
+func Bytes32Decoder(data []byte) ([32]byte, error) {
+	if len(data) != 32 {
+		return [32]byte{}, errBytes32Length
+	}
+	return [32]byte(data), nil
+}
+
+// mimcBN254Pool reuses MiMC hashers so hashing does not allocate.
+var mimcBN254Pool = sync.Pool{New: func() any {
+	return &mimcBN254State{h: mimc_bn254.NewFieldHasher()}
+}}
+
+type mimcBN254State struct {
+	h   mimc_bn254.FieldHasher
+	buf [2]fr_bn254.Element
+}
+
+// MiMCBN254ElementHasher performs MiMC hash on two BN254 scalar field
+// elements. It is the fr.Element counterpart of MiMCBN254Hasher, and is safe
+// for concurrent use.
+//
+// Parameters:
+//   - a: First input value
+//   - b: Second input value
+//
+// Returns: Hash result as fr.Element
+func MiMCBN254ElementHasher(a, b fr_bn254.Element) fr_bn254.Element {
+	s := mimcBN254Pool.Get().(*mimcBN254State)
+	defer mimcBN254Pool.Put(s)
+	s.h.Reset()
+	s.buf[0], s.buf[1] = a, b
+	return s.h.SumElements(s.buf[:])
+}
+
+// BN254ElementEqual is an equality function for BN254 field elements.
+//
+// Parameters:
+//   - a: First value to compare
+//   - b: Second value to compare
+//
+// Returns: true if a equals b, false otherwise
+func BN254ElementEqual(a, b fr_bn254.Element) bool {
+	return a.Equal(&b)
+}
+
+// BN254ElementKey returns a lookup key for BN254 field elements, suitable for
+// the WithKeyIndex option.
+//
+// Parameters:
+//   - n: The value to index
+//
+// Returns: The key as a string
+func BN254ElementKey(n fr_bn254.Element) string {
+	b := n.Bytes()
+	return string(b[:])
+}
+
+// BN254ElementEncoder encodes a BN254 field element as 32 big-endian bytes.
+//
+// Parameters:
+//   - n: The value to encode
+//
+// Returns: Byte slice representation of the value
+func BN254ElementEncoder(n fr_bn254.Element) ([]byte, error) {
+	b := n.Bytes()
+	return b[:], nil
+}
+
+// BN254ElementDecoder decodes a BN254 field element from 32 big-endian bytes.
+//
+// Parameters:
+//   - data: Byte slice to decode
+//
+// Returns: Decoded value, or error if data is not a canonical field element
+func BN254ElementDecoder(data []byte) (fr_bn254.Element, error) {
+	if len(data) != fr_bn254.Bytes {
+		return fr_bn254.Element{}, errBytes32Length
+	}
+	return fr_bn254.BigEndian.Element((*[fr_bn254.Bytes]byte)(data))
+}
+
+// mimcBLS12377Pool reuses MiMC hashers so hashing does not allocate.
+var mimcBLS12377Pool = sync.Pool{New: func() any {
+	return &mimcBLS12377State{h: mimc_bls12_377.NewFieldHasher()}
+}}
+
+type mimcBLS12377State struct {
+	h   mimc_bls12_377.FieldHasher
+	buf [2]fr_bls12377.Element
+}
+
+// MiMCBLS12377ElementHasher performs MiMC hash on two BLS12-377 scalar field
+// elements. It is the fr.Element counterpart of MiMCBLS12377Hasher, and is
+// safe for concurrent use.
+//
+// Parameters:
+//   - a: First input value
+//   - b: Second input value
+//
+// Returns: Hash result as fr.Element
+func MiMCBLS12377ElementHasher(a, b fr_bls12377.Element) fr_bls12377.Element {
+	s := mimcBLS12377Pool.Get().(*mimcBLS12377State)
+	defer mimcBLS12377Pool.Put(s)
+	s.h.Reset()
+	s.buf[0], s.buf[1] = a, b
+	return s.h.SumElements(s.buf[:])
+}
+
+// BLS12377ElementEqual is an equality function for BLS12-377 field elements.
+//
+// Parameters:
+//   - a: First value to compare
+//   - b: Second value to compare
+//
+// Returns: true if a equals b, false otherwise
+func BLS12377ElementEqual(a, b fr_bls12377.Element) bool {
+	return a.Equal(&b)
+}
+
+// BLS12377ElementKey returns a lookup key for BLS12-377 field elements,
+// suitable for the WithKeyIndex option.
+//
+// Parameters:
+//   - n: The value to index
+//
+// Returns: The key as a string
+func BLS12377ElementKey(n fr_bls12377.Element) string {
+	b := n.Bytes()
+	return string(b[:])
+}
+
+// BLS12377ElementEncoder encodes a BLS12-377 field element as 32 big-endian
+// bytes.
+//
+// Parameters:
+//   - n: The value to encode
+//
+// Returns: Byte slice representation of the value
+func BLS12377ElementEncoder(n fr_bls12377.Element) ([]byte, error) {
+	b := n.Bytes()
+	return b[:], nil
+}
+
+// BLS12377ElementDecoder decodes a BLS12-377 field element from 32 big-endian
+// bytes.
+//
+// Parameters:
+//   - data: Byte slice to decode
+//
+// Returns: Decoded value, or error if data is not a canonical field element
+func BLS12377ElementDecoder(data []byte) (fr_bls12377.Element, error) {
+	if len(data) != fr_bls12377.Bytes {
+		return fr_bls12377.Element{}, errBytes32Length
+	}
+	return fr_bls12377.BigEndian.Element((*[fr_bls12377.Bytes]byte)(data))
+}
+
+// keccakPool reuses Keccak-256 states so hashing does not allocate.
+var keccakPool = sync.Pool{New: func() any {
+	return &keccakState{h: sha3.NewLegacyKeccak256()}
+}}
+
+type keccakState struct {
+	h   hash.Hash
+	buf [64]byte
+	out [32]byte
+}
+
+// Keccak256Bytes32Hasher performs Keccak-256 on the 64-byte concatenation of
+// two 32-byte nodes, as keccak256(abi.encodePacked(a, b)) does in Solidity
+// for bytes32 values. It is safe for concurrent use.
+//
+// Parameters:
+//   - a: First input value
+//   - b: Second input value
+//
+// Returns: Hash result as [32]byte
+func Keccak256Bytes32Hasher(a, b [32]byte) [32]byte {
+	s := keccakPool.Get().(*keccakState)
+	defer keccakPool.Put(s)
+	copy(s.buf[:32], a[:])
+	copy(s.buf[32:], b[:])
+	s.h.Reset()
+	s.h.Write(s.buf[:])
+	s.h.Sum(s.out[:0])
+	return s.out
+}
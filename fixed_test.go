@@ -0,0 +1,121 @@
+package leanimt
+
+import (
+	"math/big"
+	"testing"
+
+	fr_bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	fr_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
+)
+
+func TestFixedHashersDoNotAllocate(t *testing.T) {
+	var a, b [32]byte
+	a[0], b[31] = 1, 2
+	var x, y fr_bn254.Element
+	x.SetUint64(1)
+	y.SetUint64(2)
+	var u, v fr_bls12377.Element
+	u.SetUint64(1)
+	v.SetUint64(2)
+	MiMCBN254ElementHasher(x, y) // warm up the pools
+	MiMCBLS12377ElementHasher(u, v)
+
+	for name, fn := range map[string]func(){
+		"sha256":   func() { a = SHA256Bytes32Hasher(a, b) },
+		"blake2b":  func() { a = Blake2bBytes32Hasher(a, b) },
+		"bn254":    func() { x = MiMCBN254ElementHasher(x, y) },
+		"bls12377": func() { u = MiMCBLS12377ElementHasher(u, v) },
+	} {
+		if allocs := testing.AllocsPerRun(100, fn); allocs != 0 {
+			t.Errorf("%s hasher allocates %v times per hash", name, allocs)
+		}
+	}
+}
+
+func TestFixedHashersMatchBigInt(t *testing.T) {
+	const n = 13
+	bigLeaves := make([]*big.Int, n)
+	bn254Leaves := make([]fr_bn254.Element, n)
+	bls12377Leaves := make([]fr_bls12377.Element, n)
+	for i := range n {
+		bigLeaves[i] = big.NewInt(int64(i*1000 + 7))
+		bn254Leaves[i].SetBigInt(bigLeaves[i])
+		bls12377Leaves[i].SetBigInt(bigLeaves[i])
+	}
+
+	bigTree, _ := New(MiMCBN254Hasher, BigIntEqual, nil, nil, nil)
+	if err := bigTree.InsertMany(bigLeaves); err != nil {
+		t.Fatal(err)
+	}
+	bn254Tree, _ := New(MiMCBN254ElementHasher, BN254ElementEqual, nil, nil, nil)
+	if err := bn254Tree.InsertMany(bn254Leaves); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := bigTree.Root()
+	got, _ := bn254Tree.Root()
+	if got.BigInt(new(big.Int)).Cmp(want) != 0 {
+		t.Fatal("BN254 root mismatch")
+	}
+
+	bigTree, _ = New(MiMCBLS12377Hasher, BigIntEqual, nil, nil, nil)
+	if err := bigTree.InsertMany(bigLeaves); err != nil {
+		t.Fatal(err)
+	}
+	bls12377Tree, _ := New(MiMCBLS12377ElementHasher, BLS12377ElementEqual, nil, nil, nil)
+	if err := bls12377Tree.InsertMany(bls12377Leaves); err != nil {
+		t.Fatal(err)
+	}
+	want, _ = bigTree.Root()
+	gotBLS, _ := bls12377Tree.Root()
+	if gotBLS.BigInt(new(big.Int)).Cmp(want) != 0 {
+		t.Fatal("BLS12-377 root mismatch")
+	}
+
+	proof, err := bn254Tree.GenerateProof(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bn254Tree.VerifyProof(proof) {
+		t.Fatal("proof rejected")
+	}
+}
+
+func TestBytes32Persistence(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := make([][32]byte, 9)
+	for i := range leaves {
+		leaves[i][0] = byte(i) // leading zeros must survive the round trip
+	}
+	tree, _ := New(SHA256Bytes32Hasher, Bytes32Equal, database, Bytes32Encoder, Bytes32Decoder, WithKeyIndex(Bytes32Key))
+	if err := tree.InsertMany(leaves); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := New(SHA256Bytes32Hasher, Bytes32Equal, database, Bytes32Encoder, Bytes32Decoder, WithKeyIndex(Bytes32Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := tree.Root()
+	if got, _ := loaded.Root(); got != want {
+		t.Fatal("root mismatch after reload")
+	}
+	if loaded.IndexOf(leaves[4]) != 4 {
+		t.Fatal("leaf index not rebuilt")
+	}
+
+	if _, err := Bytes32Decoder(make([]byte, 31)); err == nil {
+		t.Fatal("expected an error decoding 31 bytes")
+	}
+	var modulus [32]byte
+	fr_bn254.Modulus().FillBytes(modulus[:])
+	if _, err := BN254ElementDecoder(modulus[:]); err == nil {
+		t.Fatal("expected an error decoding a non-canonical element")
+	}
+}
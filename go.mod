@@ -7,6 +7,8 @@ require (
 	github.com/consensys/gnark-crypto v0.19.3-0.20251208215708-a16777bf2020
 	github.com/ethereum/go-ethereum v1.16.7
 	github.com/iden3/go-iden3-crypto v0.0.18-0.20241128121142-625bf563ffc5
+	github.com/klauspost/compress v1.18.2
+	github.com/prometheus/client_golang v1.23.2
 	github.com/vocdoni/davinci-node v0.0.0-20251231145653-c809413014e0
 	github.com/vocdoni/gnark-crypto-primitives v0.0.2-0.20260218072319-01482f6ccc38
 	golang.org/x/crypto v0.46.0
@@ -42,7 +44,6 @@ require (
 	github.com/golang/snappy v1.0.0 // indirect
 	github.com/google/pprof v0.0.0-20250820193118-f64d9cf942d6 // indirect
 	github.com/holiman/uint256 v1.3.2 // indirect
-	github.com/klauspost/compress v1.18.2 // indirect
 	github.com/klauspost/cpuid/v2 v2.3.0 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
@@ -56,7 +57,6 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/pressly/goose/v3 v3.26.0 // indirect
-	github.com/prometheus/client_golang v1.23.2 // indirect
 	github.com/prometheus/client_model v0.6.2 // indirect
 	github.com/prometheus/common v0.67.4 // indirect
 	github.com/prometheus/procfs v0.19.2 // indirect
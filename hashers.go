@@ -3,10 +3,14 @@ package leanimt
 import (
 	"crypto/sha256"
 	"math/big"
+	"sync"
 
 	fr_bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
 	mimc_bls12_377 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr/mimc"
+	poseidon2_bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr/poseidon2"
+	fr_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	mimc_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	poseidon2_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon2"
 	iden3mimc7 "github.com/iden3/go-iden3-crypto/mimc7"
 	iden3poseidon "github.com/iden3/go-iden3-crypto/poseidon"
 	multiposeidon "github.com/vocdoni/davinci-node/crypto/hash/poseidon"
@@ -276,6 +280,182 @@ func MultiPoseidonHasher(a, b *big.Int) *big.Int {
 	return out
 }
 
+// Domain tags for PoseidonLeafHasher and PoseidonNodeHasher. Leaves and
+// internal nodes hashed with different tags can't be mistaken for each
+// other.
+const (
+	LeafDomainTag = 0
+	NodeDomainTag = 1
+)
+
+// PoseidonLeafHasher returns a LeafHasher computing Poseidon(tag, leaf), to
+// be used WithLeafHasher together with a PoseidonNodeHasher of a different
+// tag. The circuit package provides the matching PoseidonLeafHash.
+//
+// Parameters:
+//   - tag: Domain tag of the leaves, usually LeafDomainTag
+//
+// Returns: The leaf hasher
+func PoseidonLeafHasher(tag int64) LeafHasher[*big.Int] {
+	t := big.NewInt(tag)
+	return func(leaf *big.Int) *big.Int {
+		out, err := iden3poseidon.Hash([]*big.Int{t, leaf})
+		if err != nil {
+			panic(err) // Should not happen with valid inputs
+		}
+		return out
+	}
+}
+
+// PoseidonNodeHasher returns a Hasher computing Poseidon(tag, a, b), which
+// separates internal nodes from leaves hashed by a PoseidonLeafHasher of a
+// different tag. The circuit package provides the matching PoseidonNodeHash.
+//
+// Parameters:
+//   - tag: Domain tag of the internal nodes, usually NodeDomainTag
+//
+// Returns: The node hasher
+func PoseidonNodeHasher(tag int64) Hasher[*big.Int] {
+	t := big.NewInt(tag)
+	return func(a, b *big.Int) *big.Int {
+		out, err := iden3poseidon.Hash([]*big.Int{t, a, b})
+		if err != nil {
+			panic(err) // Should not happen with valid inputs
+		}
+		return out
+	}
+}
+
+// poseidon2BN254 and poseidon2BLS12377 are the 2-to-1 Poseidon2 permutations
+// with the gnark-crypto default parameters. They only hold the round keys,
+// so they are safe for concurrent use.
+var (
+	poseidon2BN254 = sync.OnceValue(func() *poseidon2_bn254.Permutation {
+		p := poseidon2_bn254.GetDefaultParameters()
+		return poseidon2_bn254.NewPermutation(p.Width, p.NbFullRounds, p.NbPartialRounds)
+	})
+	poseidon2BLS12377 = sync.OnceValue(func() *poseidon2_bls12377.Permutation {
+		p := poseidon2_bls12377.GetDefaultParameters()
+		return poseidon2_bls12377.NewPermutation(p.Width, p.NbFullRounds, p.NbPartialRounds)
+	})
+)
+
+// Poseidon2Hasher performs Poseidon2 compression on two big.Int values over the
+// BN254 scalar field. Poseidon2 is a faster variant of Poseidon that needs
+// considerably fewer constraints in circuits; the result is the right lane of
+// the width-2 permutation of (a, b) plus b, as computed by gnark's Poseidon2
+// gadget and the circuit package's Poseidon2Hash.
+//
+// This hasher is suitable for:
+//   - Merkle trees verified in gnark circuits over BN254
+//   - Applications where the in-circuit cost of Poseidon matters
+//
+// Poseidon2Hasher is not compatible with PoseidonHasher: trees built with one
+// have different roots with the other.
+//
+// Parameters:
+//   - a: First input value
+//   - b: Second input value
+//
+// Returns: Hash result as *big.Int
+// Inputs are reduced modulo the BN254 field order before hashing
+func Poseidon2Hasher(a, b *big.Int) *big.Int {
+	var x [2]fr_bn254.Element
+	x[0].SetBigInt(a)
+	x[1].SetBigInt(b)
+	right := x[1]
+	if err := poseidon2BN254().Permutation(x[:]); err != nil {
+		panic(err) // Should not happen with a width of 2
+	}
+	x[1].Add(&x[1], &right)
+	return x[1].BigInt(new(big.Int))
+}
+
+// Poseidon2BLS12377Hasher performs Poseidon2 compression on two big.Int values
+// over the BLS12-377 scalar field. It is the BLS12-377 counterpart of
+// Poseidon2Hasher, for circuits compiled over BLS12-377.
+//
+// Parameters:
+//   - a: First input value
+//   - b: Second input value
+//
+// Returns: Hash result as *big.Int
+// Inputs are reduced modulo the BLS12-377 field order before hashing
+func Poseidon2BLS12377Hasher(a, b *big.Int) *big.Int {
+	var x [2]fr_bls12377.Element
+	x[0].SetBigInt(a)
+	x[1].SetBigInt(b)
+	right := x[1]
+	if err := poseidon2BLS12377().Permutation(x[:]); err != nil {
+		panic(err) // Should not happen with a width of 2
+	}
+	x[1].Add(&x[1], &right)
+	return x[1].BigInt(new(big.Int))
+}
+
+// Keccak256Hasher performs Keccak-256 on two big.Int values encoded as 32-byte
+// big-endian words, matching keccak256(abi.encodePacked(a, b)) for uint256 or
+// bytes32 values in Solidity. Roots and proofs built with it can be checked
+// by on-chain verifiers that hash the ordered pair (left, right) at each
+// level, such as a LeanIMT implemented with keccak256.
+//
+// This hasher is suitable for:
+//   - Merkle trees verified by EVM smart contracts
+//   - Compatibility with Solidity Merkle tree libraries
+//
+// Parameters:
+//   - a: First input value, non-negative and at most 256 bits
+//   - b: Second input value, non-negative and at most 256 bits
+//
+// Returns: Hash result as *big.Int
+// Panics if an input is negative or longer than 256 bits
+func Keccak256Hasher(a, b *big.Int) *big.Int {
+	out := Keccak256Bytes32Hasher(word(a), word(b))
+	return new(big.Int).SetBytes(out[:])
+}
+
+// SHA256FixedHasher performs SHA-256 on two big.Int values encoded as 32-byte
+// big-endian words, matching sha256(abi.encodePacked(a, b)) in Solidity.
+//
+// Unlike SHA256Hasher, which hashes the variable-length Bytes() of its inputs
+// (so different pairs such as (1, 256) and (256, 1) can collide), each input
+// always takes 32 bytes.
+//
+// Parameters:
+//   - a: First input value, non-negative and at most 256 bits
+//   - b: Second input value, non-negative and at most 256 bits
+//
+// Returns: Hash result as *big.Int
+// Panics if an input is negative or longer than 256 bits
+func SHA256FixedHasher(a, b *big.Int) *big.Int {
+	out := SHA256Bytes32Hasher(word(a), word(b))
+	return new(big.Int).SetBytes(out[:])
+}
+
+// Blake2bFixedHasher performs BLAKE2b-256 on two big.Int values encoded as
+// 32-byte big-endian words. It is the unambiguous variant of Blake2bHasher.
+//
+// Parameters:
+//   - a: First input value, non-negative and at most 256 bits
+//   - b: Second input value, non-negative and at most 256 bits
+//
+// Returns: Hash result as *big.Int
+// Panics if an input is negative or longer than 256 bits
+func Blake2bFixedHasher(a, b *big.Int) *big.Int {
+	out := Blake2bBytes32Hasher(word(a), word(b))
+	return new(big.Int).SetBytes(out[:])
+}
+
+// word encodes n as a 32-byte big-endian word.
+func word(n *big.Int) [32]byte {
+	if n.Sign() < 0 || n.BitLen() > 256 {
+		panic("value is not a 256-bit unsigned integer")
+	}
+	var w [32]byte
+	n.FillBytes(w[:])
+	return w
+}
+
 // BigIntEqual is an equality function for *big.Int values.
 // This function is used by the LeanIMT to compare values for equality.
 //
@@ -288,6 +468,33 @@ func BigIntEqual(a, b *big.Int) bool {
 	return a.Cmp(b) == 0
 }
 
+// BigIntKey returns a lookup key for *big.Int values, suitable for the
+// WithKeyIndex option. The key is a tag byte for nil, negative and
+// non-negative values followed by the big-endian magnitude, so two values
+// produce the same key if and only if BigIntEqual reports them as equal.
+//
+// Parameters:
+//   - n: The big.Int value to index
+//
+// Returns: The key as a string
+func BigIntKey(n *big.Int) string {
+	if n == nil {
+		return string([]byte{bigIntKeyNil})
+	}
+	tag := byte(bigIntKeyPositive)
+	if n.Sign() < 0 {
+		tag = bigIntKeyNegative
+	}
+	return string(append([]byte{tag}, n.Bytes()...))
+}
+
+// Tag bytes of the keys returned by BigIntKey.
+const (
+	bigIntKeyNil = iota
+	bigIntKeyNegative
+	bigIntKeyPositive
+)
+
 // BigIntEncoder encodes a *big.Int to bytes using big-endian format.
 // This function is used by the LeanIMT for persistence operations.
 // It explicitly handles zero values to ensure they are properly encoded.
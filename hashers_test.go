@@ -0,0 +1,126 @@
+package leanimt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	fr_bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	fr_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// evmVerify mirrors an on-chain verifier hashing
+// keccak256(abi.encodePacked(left, right)) along the proof path.
+func evmVerify(proof MerkleProof[*big.Int]) bool {
+	node := word(proof.Leaf)
+	for i, sibling := range proof.Siblings {
+		s := word(sibling)
+		if proof.PathBits>>uint(i)&1 == 1 {
+			node = [32]byte(crypto.Keccak256(s[:], node[:]))
+		} else {
+			node = [32]byte(crypto.Keccak256(node[:], s[:]))
+		}
+	}
+	return node == word(proof.Root)
+}
+
+func TestKeccak256Hasher(t *testing.T) {
+	// keccak256(abi.encodePacked(bytes32(0), bytes32(0)))
+	zero := Keccak256Hasher(big.NewInt(0), big.NewInt(0))
+	if hex.EncodeToString(zero.Bytes()) != "ad3228b676f7d3cd4284a5443f17f1962b36e491b30a40b2405849e597ba5fb5" {
+		t.Fatalf("unexpected hash of two zero words %x", zero)
+	}
+
+	a, b := big.NewInt(1), new(big.Int).Lsh(big.NewInt(1), 255)
+	wa, wb := word(a), word(b)
+	got := Keccak256Bytes32Hasher(wa, wb)
+	if !bytes.Equal(got[:], crypto.Keccak256(wa[:], wb[:])) {
+		t.Fatal("Keccak256Bytes32Hasher differs from go-ethereum")
+	}
+	if Keccak256Hasher(a, b).Cmp(new(big.Int).SetBytes(got[:])) != 0 {
+		t.Fatal("Keccak256Hasher differs from Keccak256Bytes32Hasher")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { got = Keccak256Bytes32Hasher(got, wb) }); allocs != 0 {
+		t.Fatalf("Keccak256Bytes32Hasher allocates %v times per hash", allocs)
+	}
+
+	tree, _ := New(Keccak256Hasher, BigIntEqual, nil, nil, nil)
+	if err := tree.InsertMany(manyLeaves(1, 11)); err != nil {
+		t.Fatal(err)
+	}
+	for i := range tree.Size() {
+		proof, err := tree.GenerateProof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !evmVerify(proof) {
+			t.Fatalf("proof %d rejected by the EVM-style verifier", i)
+		}
+	}
+}
+
+func TestFixedWidthHashers(t *testing.T) {
+	// variable-length hashers collide on ambiguous encodings
+	a, b := big.NewInt(1), big.NewInt(256)
+	c, d := big.NewInt(0x10100), big.NewInt(0)
+	if SHA256Hasher(a, b).Cmp(SHA256Hasher(c, d)) != 0 {
+		t.Fatal("expected SHA256Hasher to collide")
+	}
+	if SHA256FixedHasher(a, b).Cmp(SHA256FixedHasher(c, d)) == 0 {
+		t.Fatal("SHA256FixedHasher collides")
+	}
+	if Blake2bFixedHasher(a, b).Cmp(Blake2bFixedHasher(c, d)) == 0 {
+		t.Fatal("Blake2bFixedHasher collides")
+	}
+	wa, wb := word(a), word(b)
+	if out := SHA256Bytes32Hasher(wa, wb); SHA256FixedHasher(a, b).Cmp(new(big.Int).SetBytes(out[:])) != 0 {
+		t.Fatal("SHA256FixedHasher differs from SHA256Bytes32Hasher")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for a 257-bit input")
+		}
+	}()
+	Keccak256Hasher(new(big.Int).Lsh(big.NewInt(1), 256), a)
+}
+
+func TestPoseidon2Hasher(t *testing.T) {
+	a, b := big.NewInt(1), big.NewInt(2)
+	for _, tc := range []struct {
+		name string
+		hash Hasher[*big.Int]
+		perm interface {
+			Compress(left, right []byte) ([]byte, error)
+		}
+		modulus *big.Int
+	}{
+		{"bn254", Poseidon2Hasher, poseidon2BN254(), fr_bn254.Modulus()},
+		{"bls12-377", Poseidon2BLS12377Hasher, poseidon2BLS12377(), fr_bls12377.Modulus()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var wa, wb [32]byte
+			a.FillBytes(wa[:])
+			b.FillBytes(wb[:])
+			want, err := tc.perm.Compress(wa[:], wb[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tc.hash(a, b); got.Cmp(new(big.Int).SetBytes(want)) != 0 {
+				t.Fatalf("got %s, want %x", got, want)
+			}
+			if tc.hash(a, b).Cmp(tc.hash(b, a)) == 0 {
+				t.Fatal("hash is symmetric")
+			}
+			// inputs are reduced modulo the field order
+			if tc.hash(new(big.Int).Add(a, tc.modulus), b).Cmp(tc.hash(a, b)) != 0 {
+				t.Fatal("input not reduced")
+			}
+		})
+	}
+	if Poseidon2Hasher(a, b).Cmp(PoseidonHasher(a, b)) == 0 {
+		t.Fatal("Poseidon2Hasher matches PoseidonHasher")
+	}
+}
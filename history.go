@@ -0,0 +1,216 @@
+package leanimt
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/vocdoni/davinci-node/db"
+)
+
+// RootEntry is a root recorded in the root history together with the number
+// of leaves the tree had when the root was computed.
+type RootEntry[N any] struct {
+	Root N
+	Size int
+}
+
+// rootHistory is a fixed-capacity ring buffer of the most recent roots.
+type rootHistory[N any] struct {
+	entries []RootEntry[N]
+	start   int // position of the oldest entry
+	count   int
+}
+
+// newRootHistory creates an empty history able to hold capacity roots.
+func newRootHistory[N any](capacity int) *rootHistory[N] {
+	return &rootHistory[N]{entries: make([]RootEntry[N], capacity)}
+}
+
+// push records a new entry, evicting the oldest one when full.
+func (h *rootHistory[N]) push(e RootEntry[N]) {
+	if len(h.entries) == 0 {
+		return
+	}
+	if h.count < len(h.entries) {
+		h.entries[(h.start+h.count)%len(h.entries)] = e
+		h.count++
+		return
+	}
+	h.entries[h.start] = e
+	h.start = (h.start + 1) % len(h.entries)
+}
+
+// at returns the i-th entry, from oldest (0) to newest (count-1).
+func (h *rootHistory[N]) at(i int) RootEntry[N] {
+	return h.entries[(h.start+i)%len(h.entries)]
+}
+
+// list returns the entries from oldest to newest.
+func (h *rootHistory[N]) list() []RootEntry[N] {
+	out := make([]RootEntry[N], h.count)
+	for i := range h.count {
+		out[i] = h.at(i)
+	}
+	return out
+}
+
+// reset removes all entries.
+func (h *rootHistory[N]) reset() {
+	h.start = 0
+	h.count = 0
+}
+
+// IsKnownRoot reports whether root is the current root or one of the roots
+// kept in the root history. Proofs generated against a known root remain
+// verifiable after later insertions, as in Semaphore-style contracts.
+func (t *LeanIMT[N]) IsKnownRoot(root N) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if current, ok := t.rootUnsafe(); ok && t.equal(current, root) {
+		return true
+	}
+	if t.history == nil {
+		return false
+	}
+	for i := t.history.count - 1; i >= 0; i-- {
+		if t.equal(t.history.at(i).Root, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// RootAt returns the most recent root recorded while the tree had size leaves.
+// The boolean is false if no such root is kept in the history.
+func (t *LeanIMT[N]) RootAt(size int) (N, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if size == len(t.nodes[0]) {
+		if root, ok := t.rootUnsafe(); ok {
+			return root, true
+		}
+	}
+	var zero N
+	if t.history == nil {
+		return zero, false
+	}
+	for i := t.history.count - 1; i >= 0; i-- {
+		if e := t.history.at(i); e.Size == size {
+			return e.Root, true
+		}
+	}
+	return zero, false
+}
+
+// RootHistory returns the recorded roots from oldest to newest. It returns
+// nil if the tree was not created WithRootHistory.
+func (t *LeanIMT[N]) RootHistory() []RootEntry[N] {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.history == nil {
+		return nil
+	}
+	return t.history.list()
+}
+
+// recordRoot appends the current root to the root history, if enabled.
+func (t *LeanIMT[N]) recordRoot() {
+	if t.history == nil {
+		return
+	}
+	if root, ok := t.rootUnsafe(); ok {
+		t.history.push(RootEntry[N]{Root: root, Size: len(t.nodes[0])})
+	}
+}
+
+// seedRootHistory makes sure the current root is the newest history entry,
+// used after loading or importing a tree.
+func (t *LeanIMT[N]) seedRootHistory() {
+	if t.history == nil {
+		return
+	}
+	root, ok := t.rootUnsafe()
+	if !ok {
+		return
+	}
+	if t.history.count > 0 {
+		last := t.history.at(t.history.count - 1)
+		if last.Size == len(t.nodes[0]) && t.equal(last.Root, root) {
+			return
+		}
+	}
+	t.history.push(RootEntry[N]{Root: root, Size: len(t.nodes[0])})
+}
+
+// writeRootHistory stores the root history in tx as root:<i> entries,
+// oldest first, and removes entries left over from a longer history. Trees
+// without a history leave the stored one alone, unless replace is set, in
+// which case it is removed.
+func (t *LeanIMT[N]) writeRootHistory(tx db.WriteTx, replace bool) error {
+	if t.history == nil && !replace {
+		return nil
+	}
+	previous := 0
+	if countBytes, err := t.db.Get([]byte("meta:roots")); err == nil {
+		previous = decodeInt(countBytes)
+	} else if err != db.ErrKeyNotFound {
+		return err
+	}
+
+	count := 0
+	if t.history != nil {
+		count = t.history.count
+	}
+	for i := range count {
+		e := t.history.at(i)
+		root, err := t.encoder(e.Root)
+		if err != nil {
+			return err
+		}
+		value := append(encodeInt(e.Size), ':')
+		value = append(value, root...)
+		if err := tx.Set([]byte("root:"+intToString(i)), value); err != nil {
+			return err
+		}
+	}
+	for i := count; i < previous; i++ {
+		if err := tx.Delete([]byte("root:" + intToString(i))); err != nil {
+			return err
+		}
+	}
+	if t.history == nil {
+		return tx.Delete([]byte("meta:roots"))
+	}
+	return tx.Set([]byte("meta:roots"), encodeInt(count))
+}
+
+// loadRootHistory restores the root history written by writeRootHistory.
+func (t *LeanIMT[N]) loadRootHistory() error {
+	if t.history == nil {
+		return nil
+	}
+	t.history.reset()
+	countBytes, err := t.db.Get([]byte("meta:roots"))
+	if err != nil {
+		if err == db.ErrKeyNotFound {
+			return nil
+		}
+		return err
+	}
+	for i := range decodeInt(countBytes) {
+		value, err := t.db.Get([]byte("root:" + intToString(i)))
+		if err != nil {
+			return err
+		}
+		sep := bytes.IndexByte(value, ':')
+		if sep < 0 {
+			return errors.New("malformed root history entry " + intToString(i))
+		}
+		root, err := t.decoder(value[sep+1:])
+		if err != nil {
+			return err
+		}
+		t.history.push(RootEntry[N]{Root: root, Size: decodeInt(value[:sep])})
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package leanimt
+
+import "container/heap"
+
+// KeyFunc maps a leaf to a comparable key used by the leaf lookup index.
+type KeyFunc[N any] func(N) string
+
+// leafIndexEntry tracks the lowest index holding a key and the other indices
+// sharing it, so duplicated leaves (e.g. zeroed census slots) stay consistent
+// in O(log n) per change.
+type leafIndexEntry struct {
+	index int        // lowest index holding the key
+	dups  *indexHeap // other indices holding the key, nil if none
+}
+
+// indexHeap is a min-heap of leaf indices. Removing an index other than the
+// minimum is deferred until it reaches the top of the heap.
+type indexHeap struct {
+	items   intHeap
+	removed map[int]struct{} // indices in items no longer holding the key
+}
+
+// push adds index i.
+func (h *indexHeap) push(i int) {
+	if _, ok := h.removed[i]; ok {
+		// i is still in items
+		delete(h.removed, i)
+		return
+	}
+	heap.Push(&h.items, i)
+}
+
+// remove drops index i, which must be in the heap.
+func (h *indexHeap) remove(i int) {
+	if h.removed == nil {
+		h.removed = make(map[int]struct{})
+	}
+	h.removed[i] = struct{}{}
+	if len(h.removed) > len(h.items)/2 {
+		// compact, so removals don't accumulate
+		live := h.items[:0]
+		for _, j := range h.items {
+			if _, ok := h.removed[j]; !ok {
+				live = append(live, j)
+			}
+		}
+		h.items, h.removed = live, nil
+		heap.Init(&h.items)
+	}
+}
+
+// popMin removes and returns the lowest index, false if the heap is empty.
+func (h *indexHeap) popMin() (int, bool) {
+	if h == nil {
+		return 0, false
+	}
+	for len(h.items) > 0 {
+		i := heap.Pop(&h.items).(int)
+		if _, ok := h.removed[i]; !ok {
+			return i, true
+		}
+		delete(h.removed, i)
+	}
+	return 0, false
+}
+
+// intHeap implements heap.Interface for indexHeap.
+type intHeap []int
+
+func (h intHeap) Len() int           { return len(h) }
+func (h intHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h intHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *intHeap) Push(x any)        { *h = append(*h, x.(int)) }
+
+func (h *intHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// rebuildIndex recomputes the leaf index from the current leaves.
+func (t *LeanIMT[N]) rebuildIndex() {
+	if t.keyFn == nil {
+		return
+	}
+	t.index = make(map[string]leafIndexEntry)
+	if len(t.nodes) == 0 {
+		return
+	}
+	for i, leaf := range t.values() {
+		t.indexAdd(leaf, i)
+	}
+}
+
+// indexAdd records that leaf is stored at index i.
+func (t *LeanIMT[N]) indexAdd(leaf N, i int) {
+	if t.keyFn == nil {
+		return
+	}
+	if t.index == nil {
+		t.index = make(map[string]leafIndexEntry)
+	}
+	key := t.keyFn(leaf)
+	e, ok := t.index[key]
+	if !ok {
+		t.index[key] = leafIndexEntry{index: i}
+		return
+	}
+	if e.dups == nil {
+		e.dups = &indexHeap{}
+	}
+	if i < e.index {
+		// a stale copy of i left in dups by a deferred removal stays
+		// marked as removed, and is skipped by popMin
+		e.dups.push(e.index)
+		e.index = i
+	} else {
+		e.dups.push(i)
+	}
+	t.index[key] = e
+}
+
+// indexRemove drops the record of leaf at index i. It must be called before
+// the leaf at i is overwritten.
+func (t *LeanIMT[N]) indexRemove(leaf N, i int) {
+	if t.keyFn == nil {
+		return
+	}
+	key := t.keyFn(leaf)
+	e, ok := t.index[key]
+	if !ok {
+		return
+	}
+	if i != e.index {
+		e.dups.remove(i)
+		return
+	}
+	next, ok := e.dups.popMin()
+	if !ok {
+		delete(t.index, key)
+		return
+	}
+	e.index = next
+	if len(e.dups.items) == 0 {
+		e.dups = nil
+	}
+	t.index[key] = e
+}
+
+// indexLookup returns the lowest index of leaf using the leaf index.
+func (t *LeanIMT[N]) indexLookup(leaf N) int {
+	e, ok := t.index[t.keyFn(leaf)]
+	if !ok {
+		return -1
+	}
+	return e.index
+}
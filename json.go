@@ -1,72 +1,358 @@
 package leanimt
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
+	"strings"
+
+	"github.com/vocdoni/davinci-node/db"
 )
 
 // Export encodes the internal matrix as JSON.
 // For *big.Int values, this results in JSON strings (via TextMarshaler),
-// matching the TS behavior that stringifies bigints.
+// matching the TS behavior that stringifies bigints. Trees created
+// WithLeafHasher export the leaves as inserted in place of level 0.
 func (t *LeanIMT[N]) Export() (string, error) {
-	b, err := json.Marshal(t.nodes)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.leafHash != nil {
+		return exportNodes(withValues(t.nodes, t.leaves))
+	}
+	return exportNodes(t.nodes)
+}
+
+// exportNodes encodes a node matrix as JSON.
+func exportNodes[N any](nodes [][]N) (string, error) {
+	b, err := json.Marshal(nodes)
 	if err != nil {
 		return "", err
 	}
 	return string(b), nil
 }
 
+// ErrInvalidImport is matched by the ImportError returned by ImportVerified
+// when the imported nodes don't match the ones rebuilt from the leaves.
+var ErrInvalidImport = errors.New("imported nodes do not match the leaves")
+
+// ImportError reports the first imported node, or level, that doesn't match
+// the tree rebuilt from the imported leaves.
+type ImportError struct {
+	Level int // level of the mismatch
+	Index int // index of the mismatching node, -1 if the level has the wrong size
+}
+
+func (e *ImportError) Error() string {
+	if e.Index < 0 {
+		return "imported level " + itoa(e.Level) + " has the wrong number of nodes"
+	}
+	return "imported node " + itoa(e.Index) + " of level " + itoa(e.Level) + " does not match its children"
+}
+
+// Is reports whether target is ErrInvalidImport.
+func (e *ImportError) Is(target error) bool {
+	return target == ErrInvalidImport
+}
+
 // Import parses a JSON-encoded nodes matrix and returns a new tree.
 // If mapFn is provided, every JSON scalar value that is encoded as a string
 // will be passed through mapFn to build values of type N.
 // If mapFn is nil, Import attempts to unmarshal directly into [][]N.
-func Import[N any](hash Hasher[N], nodesJSON string, eq Equal[N], mapFn func(string) (N, error)) (*LeanIMT[N], error) {
-	if hash == nil {
-		return nil, errors.New("parameter 'hash' is not defined")
+// Optional features are enabled through opts; WithLeafHasher hashes level 0,
+// which must hold the leaves as inserted. A matrix holding only the leaves
+// level, as written by ExportTo with ExportLeaves, is rebuilt.
+//
+// The internal nodes are trusted as given. Use ImportVerified for data that
+// may be corrupted or come from an untrusted source.
+func Import[N any](hash Hasher[N], nodesJSON string, eq Equal[N], mapFn func(string) (N, error), opts ...Option[N]) (*LeanIMT[N], error) {
+	tree, nodes, err := parseImport(hash, nodesJSON, eq, mapFn, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tree.importNodes(nodes)
+}
+
+// ImportToStorage is like Import, but writes the imported tree to storage with
+// encoder, as Persist does, and returns a tree backed by it. Any tree stored
+// there is replaced atomically. For streamed, verified or binary imports, use
+// the matching function and Persist.
+func ImportToStorage[N any](hash Hasher[N], nodesJSON string, eq Equal[N], mapFn func(string) (N, error), storage db.Database, encoder func(N) ([]byte, error), decoder func([]byte) (N, error), opts ...Option[N]) (*LeanIMT[N], error) {
+	tree, err := Import(hash, nodesJSON, eq, mapFn, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := tree.Persist(storage, encoder, decoder); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// importNodes sets the imported nodes matrix, rebuilding the tree if it only
+// holds the leaves level.
+func (t *LeanIMT[N]) importNodes(nodes [][]N) (*LeanIMT[N], error) {
+	if len(nodes) == 1 {
+		t.resetLeaves(nodes[0])
+		if err := t.rebuildTree(context.Background(), nil); err != nil {
+			return nil, err
+		}
+	} else {
+		t.setNodes(nodes)
+	}
+	if err := t.afterImport(); err != nil {
+		return nil, err
 	}
+	return t, nil
+}
+
+// ImportVerified is like Import, but rebuilds the tree from the imported
+// leaves and compares every imported level with the rebuilt one. A matrix
+// holding only the leaves level has nothing to compare. It returns
+// an ImportError on the first level with a wrong number of nodes or node
+// that doesn't match its children. Combine it WithExpectedRoot to also check
+// the resulting root.
+func ImportVerified[N any](hash Hasher[N], nodesJSON string, eq Equal[N], mapFn func(string) (N, error), opts ...Option[N]) (*LeanIMT[N], error) {
+	tree, nodes, err := parseImport(hash, nodesJSON, eq, mapFn, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tree.importVerified(nodes)
+}
+
+// importVerified rebuilds the tree from the imported leaves and checks the
+// imported levels against it.
+func (t *LeanIMT[N]) importVerified(nodes [][]N) (*LeanIMT[N], error) {
+	if len(nodes) == 0 {
+		return nil, &ImportError{Level: 0, Index: -1}
+	}
+	t.resetLeaves(nodes[0])
+	if err := t.rebuildTree(context.Background(), nil); err != nil {
+		return nil, err
+	}
+	if len(nodes) > 1 {
+		if err := t.compareLevels(nodes); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.afterImport(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// compareLevels checks the imported internal levels against the tree nodes.
+// Level 0 holds the imported leaves themselves.
+func (t *LeanIMT[N]) compareLevels(imported [][]N) error {
+	if len(imported) != len(t.nodes) {
+		return &ImportError{Level: min(len(imported), len(t.nodes)), Index: -1}
+	}
+	for level := 1; level < len(t.nodes); level++ {
+		if len(imported[level]) != len(t.nodes[level]) {
+			return &ImportError{Level: level, Index: -1}
+		}
+		for i, node := range t.nodes[level] {
+			if !t.equal(imported[level][i], node) {
+				return &ImportError{Level: level, Index: i}
+			}
+		}
+	}
+	return nil
+}
+
+// afterImport rebuilds the state derived from the imported nodes.
+func (t *LeanIMT[N]) afterImport() error {
+	if err := t.checkRoot(); err != nil {
+		return err
+	}
+	t.rebuildIndex()
+	t.seedRootHistory()
+	return nil
+}
+
+// parseImport creates the tree for an import and parses its nodes matrix.
+func parseImport[N any](hash Hasher[N], nodesJSON string, eq Equal[N], mapFn func(string) (N, error), opts []Option[N]) (*LeanIMT[N], [][]N, error) {
 	if nodesJSON == "" {
-		return nil, errors.New("parameter 'nodes' is not defined")
+		return nil, nil, errors.New("parameter 'nodes' is not defined")
+	}
+	return readImport(hash, strings.NewReader(nodesJSON), eq, mapFn, opts)
+}
+
+// readImport creates the tree for an import and reads its nodes matrix from r.
+func readImport[N any](hash Hasher[N], r io.Reader, eq Equal[N], mapFn func(string) (N, error), opts []Option[N]) (*LeanIMT[N], [][]N, error) {
+	if hash == nil {
+		return nil, nil, errors.New("parameter 'hash' is not defined")
+	}
+
+	nodes, err := readMatrix(r, mapFn)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := importTree(hash, eq, opts)
+	if err != nil {
+		return nil, nil, err
 	}
+	return tree, nodes, nil
+}
 
+// importTree creates the in-memory tree an import is loaded into.
+func importTree[N any](hash Hasher[N], eq Equal[N], opts []Option[N]) (*LeanIMT[N], error) {
 	tree := &LeanIMT[N]{
-		nodes: [][]N{ /* replaced below */ },
+		nodes: [][]N{ /* replaced by the caller */ },
 		hash:  hash,
 		eq:    eq,
 	}
+	for _, opt := range opts {
+		opt(tree)
+	}
+	id, err := resolveHasherID(hash, tree.hasherID)
+	if err != nil {
+		return nil, err
+	}
+	tree.hasherID = id
+	return tree, nil
+}
 
-	// If no map needed, try direct unmarshal into [][]N.
-	if mapFn == nil {
-		var nodes [][]N
-		if err := json.Unmarshal([]byte(nodesJSON), &nodes); err != nil {
-			return nil, err
+// ExportMode selects what ExportTo writes.
+type ExportMode int
+
+const (
+	// ExportNodes writes the whole node matrix, as Export does.
+	ExportNodes ExportMode = iota
+	// ExportLeaves writes only the leaves, as a matrix with a single level.
+	// Importing it rebuilds the internal nodes.
+	ExportLeaves
+)
+
+// ExportTo writes the tree as JSON to w, in the same format as Export, one
+// level at a time so the encoded tree is never held in memory. It exports a
+// snapshot, so writers are not blocked while w is written; the levels they
+// modify meanwhile are copied. Once ExportTo returns, writes no longer copy
+// them.
+func (t *LeanIMT[N]) ExportTo(w io.Writer, mode ExportMode) error {
+	s, release := t.tempSnapshot()
+	defer release()
+	return s.ExportTo(w, mode)
+}
+
+// ExportTo writes the snapshot as JSON to w, as LeanIMT.ExportTo.
+func (s *Snapshot[N]) ExportTo(w io.Writer, mode ExportMode) error {
+	nodes := s.nodes
+	if s.leafHash != nil {
+		nodes = withValues(nodes, s.values)
+	}
+	if mode == ExportLeaves {
+		nodes = nodes[:1]
+	}
+	return writeMatrix(w, nodes)
+}
+
+// writeMatrix encodes a node matrix as JSON to w, value by value.
+func writeMatrix[N any](w io.Writer, nodes [][]N) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('[')
+	for level, values := range nodes {
+		if level > 0 {
+			bw.WriteByte(',')
 		}
-		tree.nodes = nodes
-		return tree, nil
+		bw.WriteByte('[')
+		for i, v := range values {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			bw.Write(b)
+		}
+		bw.WriteByte(']')
 	}
+	bw.WriteByte(']')
+	return bw.Flush()
+}
 
-	// Otherwise, unmarshal into [][]any, convert strings via mapFn (only).
-	var raw [][]any
-	if err := json.Unmarshal([]byte(nodesJSON), &raw); err != nil {
+// ImportFrom is like Import, reading the JSON nodes matrix from r one value
+// at a time instead of from a string. It accepts the output of Export and
+// of ExportTo in both modes.
+func ImportFrom[N any](hash Hasher[N], r io.Reader, eq Equal[N], mapFn func(string) (N, error), opts ...Option[N]) (*LeanIMT[N], error) {
+	tree, nodes, err := readImport(hash, r, eq, mapFn, opts)
+	if err != nil {
 		return nil, err
 	}
+	return tree.importNodes(nodes)
+}
 
-	nodes := make([][]N, len(raw))
-	for i := range raw {
-		nodes[i] = make([]N, len(raw[i]))
-		for j, v := range raw[i] {
-			s, ok := v.(string)
-			if !ok {
-				// If it's not a string, try marshaling it back to JSON then feed mapFn on the string form.
-				b, _ := json.Marshal(v)
-				s = string(b)
-			}
-			val, err := mapFn(s)
+// ImportVerifiedFrom is like ImportVerified, reading the JSON nodes matrix
+// from r as ImportFrom does.
+func ImportVerifiedFrom[N any](hash Hasher[N], r io.Reader, eq Equal[N], mapFn func(string) (N, error), opts ...Option[N]) (*LeanIMT[N], error) {
+	tree, nodes, err := readImport(hash, r, eq, mapFn, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tree.importVerified(nodes)
+}
+
+// readMatrix decodes a JSON nodes matrix from r one value at a time. If
+// mapFn is provided, values encoded as strings are passed through it, and
+// other values as their JSON text; otherwise values are unmarshaled into N.
+func readMatrix[N any](r io.Reader, mapFn func(string) (N, error)) ([][]N, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '['); err != nil {
+		return nil, err
+	}
+	var nodes [][]N
+	for dec.More() {
+		if err := expectDelim(dec, '['); err != nil {
+			return nil, err
+		}
+		level := make([]N, 0)
+		for dec.More() {
+			v, err := readMatrixValue(dec, mapFn)
 			if err != nil {
 				return nil, err
 			}
-			nodes[i][j] = val
+			level = append(level, v)
 		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, level)
 	}
-	tree.nodes = nodes
-	return tree, nil
+	if err := expectDelim(dec, ']'); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// readMatrixValue decodes the next matrix value from dec.
+func readMatrixValue[N any](dec *json.Decoder, mapFn func(string) (N, error)) (N, error) {
+	var v N
+	if mapFn == nil {
+		err := dec.Decode(&v)
+		return v, err
+	}
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return v, err
+	}
+	s := string(raw)
+	if len(raw) > 0 && raw[0] == '"' {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return v, err
+		}
+	}
+	return mapFn(s)
+}
+
+// expectDelim reads the next JSON token from dec, which must be delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return errors.New("nodes matrix must be a JSON array of arrays")
+	}
+	return nil
 }
@@ -0,0 +1,304 @@
+package leanimt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
+)
+
+func TestImportVerified(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	if err := tree.InsertMany(manyLeaves(1, 7)); err != nil {
+		t.Fatal(err)
+	}
+	export, err := tree.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := tree.Root()
+
+	imported, err := ImportVerified(bigIntHasher, export, BigIntEqual, nil, WithExpectedRoot(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := imported.Root(); r.Cmp(root) != 0 {
+		t.Fatal("imported root differs")
+	}
+
+	// tamper returns the export with fn applied to its node matrix
+	tamper := func(fn func(nodes [][]*big.Int) [][]*big.Int) string {
+		var nodes [][]*big.Int
+		if err := json.Unmarshal([]byte(export), &nodes); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(fn(nodes))
+		return string(b)
+	}
+	for _, tc := range []struct {
+		name string
+		data string
+		want ImportError
+	}{
+		{"internal node", tamper(func(n [][]*big.Int) [][]*big.Int { n[1][2] = bigInt(5); return n }), ImportError{Level: 1, Index: 2}},
+		{"root", tamper(func(n [][]*big.Int) [][]*big.Int { n[3][0] = bigInt(5); return n }), ImportError{Level: 3, Index: 0}},
+		{"leaf", tamper(func(n [][]*big.Int) [][]*big.Int { n[0][6] = bigInt(5); return n }), ImportError{Level: 1, Index: 3}},
+		{"level size", tamper(func(n [][]*big.Int) [][]*big.Int { n[1] = n[1][:3]; return n }), ImportError{Level: 1, Index: -1}},
+		{"missing level", tamper(func(n [][]*big.Int) [][]*big.Int { return n[:3] }), ImportError{Level: 3, Index: -1}},
+		{"extra level", tamper(func(n [][]*big.Int) [][]*big.Int { return append(n, n[3]) }), ImportError{Level: 4, Index: -1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ImportVerified(bigIntHasher, tc.data, BigIntEqual, nil)
+			var importErr *ImportError
+			if !errors.Is(err, ErrInvalidImport) || !errors.As(err, &importErr) || *importErr != tc.want {
+				t.Fatalf("got %v, want %v", err, &tc.want)
+			}
+		})
+	}
+
+	// Import trusts the nodes, but still honors the expected root
+	if _, err := Import(bigIntHasher, export, BigIntEqual, nil, WithExpectedRoot(bigInt(1))); !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("expected ErrRootMismatch, got %v", err)
+	}
+	if _, err := ImportVerified(bigIntHasher, export, BigIntEqual, nil, WithExpectedRoot(bigInt(1))); !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("expected ErrRootMismatch, got %v", err)
+	}
+}
+
+func TestLoadExpectedRoot(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	tree, _ := New(bigIntHasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder)
+	if err := tree.InsertMany(manyLeaves(1, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	root, _ := tree.Root()
+
+	if _, err := New(bigIntHasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder, WithExpectedRoot(root)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(bigIntHasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder, WithExpectedRoot(bigInt(1))); !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("expected ErrRootMismatch, got %v", err)
+	}
+}
+
+func TestExportTo(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	if err := tree.InsertMany(manyLeaves(1, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	root, _ := tree.Root()
+	export, err := tree.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tree.ExportTo(&buf, ExportNodes); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != export {
+		t.Fatal("ExportTo output differs from Export")
+	}
+	imported, err := ImportFrom(bigIntHasher, &buf, BigIntEqual, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(imported.nodes[1], tree.nodes[1], BigIntEqual) {
+		t.Fatal("imported nodes differ")
+	}
+
+	buf.Reset()
+	if err := tree.ExportTo(&buf, ExportLeaves); err != nil {
+		t.Fatal(err)
+	}
+	var leaves [][]*big.Int
+	if err := json.Unmarshal(buf.Bytes(), &leaves); err != nil || len(leaves) != 1 {
+		t.Fatalf("leaves-only export is not a single level matrix: %v", err)
+	}
+	data := buf.String()
+	for name, imp := range map[string]func() (*LeanIMT[*big.Int], error){
+		"Import": func() (*LeanIMT[*big.Int], error) { return Import(bigIntHasher, data, BigIntEqual, nil) },
+		"ImportFrom": func() (*LeanIMT[*big.Int], error) {
+			return ImportFrom(bigIntHasher, strings.NewReader(data), BigIntEqual, nil)
+		},
+		"ImportVerifiedFrom": func() (*LeanIMT[*big.Int], error) {
+			return ImportVerifiedFrom(bigIntHasher, strings.NewReader(data), BigIntEqual, nil)
+		},
+	} {
+		imported, err := imp()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if r, _ := imported.Root(); r.Cmp(root) != 0 || imported.Size() != tree.Size() {
+			t.Fatalf("%s: rebuilt tree differs", name)
+		}
+	}
+
+	// values encoded as strings go through mapFn
+	mapped, err := ImportFrom(bigIntHasher, strings.NewReader(`[["1","2","3"]]`), BigIntEqual, func(s string) (*big.Int, error) {
+		n, _ := new(big.Int).SetString(s, 10)
+		return n, nil
+	})
+	if err != nil || mapped.Size() != 3 {
+		t.Fatalf("unexpected mapped import %v", err)
+	}
+	if _, err := ImportFrom(bigIntHasher, strings.NewReader(`{"nodes":[]}`), BigIntEqual, nil); err == nil {
+		t.Fatal("expected error for a non-matrix document")
+	}
+}
+
+func TestExportToLeafHasher(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil, WithLeafHasher(testLeafHash))
+	if err := tree.InsertMany(manyLeaves(1, 5)); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tree.ExportTo(&buf, ExportLeaves); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := ImportFrom(bigIntHasher, &buf, BigIntEqual, nil, WithLeafHasher(testLeafHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := tree.Root()
+	if r, _ := imported.Root(); r.Cmp(root) != 0 || !slices.EqualFunc(imported.Leaves(), manyLeaves(1, 5), BigIntEqual) {
+		t.Fatal("imported tree differs")
+	}
+}
+
+func TestImportToStorage(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	open := func(opts ...Option[*big.Int]) *LeanIMT[*big.Int] {
+		tree, err := New(bigIntHasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+
+	// a larger tree already stored is replaced
+	stored := open()
+	if err := stored.InsertMany(manyLeaves(100, 50)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stored.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	source, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	if err := source.InsertMany(manyLeaves(1, 20)); err != nil {
+		t.Fatal(err)
+	}
+	export, _ := source.Export()
+	root, _ := source.Root()
+	tree, err := ImportToStorage(bigIntHasher, export, BigIntEqual, nil, database, BigIntEncoder, BigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := open().Root(); r.Cmp(root) != 0 {
+		t.Fatal("stored root differs from the imported one")
+	}
+
+	// the imported tree syncs its changes like one opened with New
+	tree.Insert(bigInt(21))
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := open()
+	if !slices.EqualFunc(loaded.Leaves(), manyLeaves(1, 21), BigIntEqual) {
+		t.Fatalf("unexpected stored leaves %v", loaded.Leaves())
+	}
+	if err := tree.Persist(database, BigIntEncoder, BigIntDecoder); err == nil {
+		t.Fatal("expected error persisting a tree that has storage")
+	}
+
+	// trees built by other means are persisted with their nodes
+	named, _ := NewNamed(HasherSHA256, BigIntEqual, nil, nil, nil, WithNodePersistence[*big.Int]())
+	if err := named.InsertMany(manyLeaves(1, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := named.Persist(database, BigIntEncoder, BigIntDecoder); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewNamed(HasherSHA256, BigIntEqual, database, BigIntEncoder, BigIntDecoder, WithNodePersistence[*big.Int]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	namedRoot, _ := named.Root()
+	if r, _ := reopened.Root(); r.Cmp(namedRoot) != 0 || reopened.Size() != 5 {
+		t.Fatal("reopened tree differs from the persisted one")
+	}
+
+	other, _ := NewNamed(HasherKeccak256, BigIntEqual, nil, nil, nil)
+	if err := other.Persist(database, BigIntEncoder, BigIntDecoder); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatalf("expected ErrHasherMismatch, got %v", err)
+	}
+	if err := other.Persist(database, BigIntEncoder, BigIntDecoder); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatal("tree kept the storage of a failed Persist")
+	}
+
+	// storage written by a newer version is refused and left unchanged
+	newer, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := TreeSchema.Stamp(newer, SchemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	var schemaErr *SchemaError
+	if err := other.Persist(newer, BigIntEncoder, BigIntDecoder); !errors.As(err, &schemaErr) {
+		t.Fatalf("expected a SchemaError, got %v", err)
+	}
+	if _, err := newer.Get([]byte("meta:size")); err != db.ErrKeyNotFound {
+		t.Fatal("storage of a newer version was written")
+	}
+
+	// a failed write leaves the tree in memory, so it can be persisted again
+	if err := other.InsertMany(manyLeaves(1, 7)); err != nil {
+		t.Fatal(err)
+	}
+	empty, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirty := other.dirty
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := other.PersistContext(ctx, empty, BigIntEncoder, BigIntDecoder, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if other.db != nil || other.dirty != dirty || other.synced != 0 || other.encoder != nil {
+		t.Fatal("tree state not restored after a failed Persist")
+	}
+	if _, err := empty.Get([]byte("meta:size")); err != db.ErrKeyNotFound {
+		t.Fatal("storage written by a cancelled Persist")
+	}
+	if err := other.Persist(empty, BigIntEncoder, BigIntDecoder); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewNamed(HasherKeccak256, BigIntEqual, empty, BigIntEncoder, BigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(reloaded.Leaves(), manyLeaves(1, 7), BigIntEqual) {
+		t.Fatal("stored leaves differ from the persisted tree")
+	}
+}
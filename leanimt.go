@@ -1,14 +1,22 @@
 package leanimt
 
 import (
+	"context"
 	"errors"
+	"maps"
 	"reflect"
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"github.com/vocdoni/davinci-node/db"
 	"github.com/vocdoni/davinci-node/db/metadb"
 )
 
+// ErrRootMismatch is returned when an imported or loaded tree doesn't have the
+// root given WithExpectedRoot.
+var ErrRootMismatch = errors.New("tree root does not match the expected root")
+
 // Hasher is the binary hash used for internal nodes.
 type Hasher[N any] func(a, b N) N
 
@@ -27,22 +35,48 @@ type LeanIMT[N any] struct {
 	nodes   [][]N
 	hash    Hasher[N]
 	eq      Equal[N]
-	db      db.Database             // nil for in-memory only
-	encoder func(N) ([]byte, error) // serialize leaf to bytes
-	decoder func([]byte) (N, error) // deserialize bytes to leaf
-	dirty   bool                    // track if changes need syncing
+	db      db.Database               // nil for in-memory only
+	encoder func(N) ([]byte, error)   // serialize leaf to bytes
+	decoder func([]byte) (N, error)   // deserialize bytes to leaf
+	dirty   bool                      // track if changes need syncing
+	keyFn   KeyFunc[N]                // optional leaf key for the lookup index
+	index   map[string]leafIndexEntry // leaf key -> lowest index (nil if keyFn is nil)
+	history *rootHistory[N]           // recent roots (nil if disabled)
+	shared  []int                     // per level, prefix length referenced by snapshots
+	taken   uint64                    // snapshots taken, to release the levels of temporary ones
+	synced  int                       // number of leaves persisted by the last Sync
+	updated map[int]struct{}          // persisted leaves modified since the last Sync
+
+	persistNodes bool // store internal nodes so Load doesn't rehash
+	verifyNodes  bool // check every stored node against its children on Load
+	nodesSynced  bool // stored internal nodes match the last Sync
+	workers      int  // goroutines used to hash large levels (<= 1: sequential)
+
+	hasherID string // registered name of hash, stored in meta:hasher ("" if unregistered)
+
+	leafHash LeafHasher[N] // hashes leaves into level 0 (nil: leaves are nodes)
+	leaves   []N           // leaves as inserted, only kept with leafHash
+
+	expectedRoot *N // root required after Import and Load (nil: any)
+
+	observer Observer      // receives operation statistics (nil if disabled)
+	hashes   *atomic.Int64 // hashes computed, counted only when observed
+
+	feed RootFeed[N] // root change subscribers
 }
 
 // New creates a new empty LeanIMT with the provided hash function.
 // If eq is nil, reflect.DeepEqual is used for equality.
 // If storage is nil, the tree operates in memory-only mode.
 // If storage is provided, encoder and decoder functions must also be provided.
+// Optional features are enabled through opts.
 //
 // Example usage:
 //
 //	tree, err := New(BigIntHasher, BigIntEqual, nil, nil, nil)                    // in-memory
 //	tree, err := New(BigIntHasher, BigIntEqual, db, BigIntEncoder, BigIntDecoder) // persistent
-func New[N any](hash Hasher[N], eq Equal[N], storage db.Database, encoder func(N) ([]byte, error), decoder func([]byte) (N, error)) (*LeanIMT[N], error) {
+//	tree, err := New(BigIntHasher, BigIntEqual, nil, nil, nil, WithKeyIndex(BigIntKey))
+func New[N any](hash Hasher[N], eq Equal[N], storage db.Database, encoder func(N) ([]byte, error), decoder func([]byte) (N, error), opts ...Option[N]) (*LeanIMT[N], error) {
 	if hash == nil {
 		return nil, errors.New("parameter 'hash' is not defined")
 	}
@@ -59,6 +93,15 @@ func New[N any](hash Hasher[N], eq Equal[N], storage db.Database, encoder func(N
 		decoder: decoder,
 		dirty:   false,
 	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	id, err := resolveHasherID(hash, t.hasherID)
+	if err != nil {
+		return nil, err
+	}
+	t.hasherID = id
+	t.rebuildIndex()
 
 	// Try to load existing tree from database if storage is provided
 	if storage != nil {
@@ -68,7 +111,7 @@ func New[N any](hash Hasher[N], eq Equal[N], storage db.Database, encoder func(N
 			if err != db.ErrKeyNotFound {
 				return nil, err // Return actual errors, not just key not found
 			}
-			t.nodes = [][]N{make([]N, 0)}
+			t.resetLeaves(make([]N, 0))
 		}
 	}
 
@@ -76,7 +119,7 @@ func New[N any](hash Hasher[N], eq Equal[N], storage db.Database, encoder func(N
 }
 
 // NewWithPebble is a wrapper around New. Creates a new LeanIMT using a persistent Pebble DB at the specified directory.
-func NewWithPebble[N any](hash Hasher[N], eq Equal[N], encoder func(N) ([]byte, error), decoder func([]byte) (N, error), datadir string) (*LeanIMT[N], error) {
+func NewWithPebble[N any](hash Hasher[N], eq Equal[N], encoder func(N) ([]byte, error), decoder func([]byte) (N, error), datadir string, opts ...Option[N]) (*LeanIMT[N], error) {
 	if encoder == nil || decoder == nil {
 		return nil, errors.New("encoder and decoder functions are required for persistent storage")
 	}
@@ -86,7 +129,7 @@ func NewWithPebble[N any](hash Hasher[N], eq Equal[N], encoder func(N) ([]byte,
 		return nil, err
 	}
 
-	return New(hash, eq, database, encoder, decoder)
+	return New(hash, eq, database, encoder, decoder, opts...)
 }
 
 // equal compares two values, using provided eq if present, otherwise reflect.DeepEqual.
@@ -115,8 +158,9 @@ func (t *LeanIMT[N]) Size() int {
 func (t *LeanIMT[N]) Leaves() []N {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
-	cp := make([]N, len(t.nodes[0]))
-	copy(cp, t.nodes[0])
+	values := t.values()
+	cp := make([]N, len(values))
+	copy(cp, values)
 	return cp
 }
 
@@ -129,18 +173,25 @@ func (t *LeanIMT[N]) Root() (N, bool) {
 
 // rootUnsafe returns the root without acquiring locks (internal use).
 func (t *LeanIMT[N]) rootUnsafe() (N, bool) {
+	return rootOf(t.nodes)
+}
+
+// rootOf returns the root of a node matrix, if any.
+func rootOf[N any](nodes [][]N) (N, bool) {
 	var zero N
-	if len(t.nodes) == 0 {
+	if len(nodes) == 0 {
 		return zero, false
 	}
-	depth := len(t.nodes) - 1
-	if depth < 0 || len(t.nodes[depth]) == 0 {
+	depth := len(nodes) - 1
+	if depth < 0 || len(nodes[depth]) == 0 {
 		return zero, false
 	}
-	return t.nodes[depth][0], true
+	return nodes[depth][0], true
 }
 
 // IndexOf returns the index of a leaf by equality; -1 if not present.
+// It runs in constant time when the tree was created WithKeyIndex, otherwise
+// it scans the leaves.
 func (t *LeanIMT[N]) IndexOf(leaf N) int {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -149,7 +200,10 @@ func (t *LeanIMT[N]) IndexOf(leaf N) int {
 
 // indexOfUnsafe returns the index of a leaf without acquiring locks (internal use).
 func (t *LeanIMT[N]) indexOfUnsafe(leaf N) int {
-	for i, v := range t.nodes[0] {
+	if t.keyFn != nil {
+		return t.indexLookup(leaf)
+	}
+	for i, v := range t.values() {
 		if t.equal(v, leaf) {
 			return i
 		}
@@ -166,20 +220,20 @@ func (t *LeanIMT[N]) Has(leaf N) bool {
 
 // Insert inserts a single leaf at the end, updating path to root bottom-up.
 func (t *LeanIMT[N]) Insert(leaf N) int {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	op := t.lock(OpInsert)
+	defer t.unlock(op, 1, 0, nil)
+	old, _ := t.rootUnsafe()
 	// If next depth increases, add a level.
 	nextSize := len(t.nodes[0]) + 1
 	if len(t.nodes)-1 < ceilLog2(nextSize) {
 		t.nodes = append(t.nodes, make([]N, 0)) // new level
 	}
 
-	node := leaf
 	index := len(t.nodes[0]) // index of the new leaf
 
 	// ensure capacity at leaves and set
-	ensureIndex(&t.nodes[0], index)
-	t.nodes[0][index] = node
+	node := t.setLeaf(index, leaf)
+	t.indexAdd(leaf, index)
 	finalIndex := index
 
 	// Update parents up to last-but-top; top is assigned after loop.
@@ -187,6 +241,7 @@ func (t *LeanIMT[N]) Insert(leaf N) int {
 	for level := range depth {
 		// For non-leaf levels, store the node at [level][index], then compute parent if right child.
 		if level > 0 {
+			t.unshare(level, index)
 			ensureIndex(&t.nodes[level], index)
 			t.nodes[level][index] = node
 		}
@@ -202,26 +257,62 @@ func (t *LeanIMT[N]) Insert(leaf N) int {
 
 	// store root as the single element on the top level
 	top := depth
+	t.unshare(top, 0)
 	t.nodes[top] = t.nodes[top][:0]
 	t.nodes[top] = append(t.nodes[top], node)
 
 	t.markDirty()
+	t.recordRoot()
+	t.notify(old, func() []int { return []int{finalIndex} })
 	return finalIndex
 }
 
 // InsertMany inserts m leaves in batch (more efficient than m x Insert).
 func (t *LeanIMT[N]) InsertMany(leaves []N) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	return t.InsertManyContext(context.Background(), leaves, nil)
+}
+
+// InsertManyContext is like InsertMany but stops when ctx is done, returning
+// ctx.Err() and leaving the tree unchanged. If progress is not nil it is
+// called periodically while the new nodes are hashed.
+func (t *LeanIMT[N]) InsertManyContext(ctx context.Context, leaves []N, progress ProgressFunc) (err error) {
+	op := t.lock(OpInsertMany)
+	defer func() { t.unlock(op, len(leaves), 0, err) }()
 
 	if len(leaves) == 0 {
 		return errors.New("there are no leaves to add")
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	startIndex := len(t.nodes[0]) >> 1
+	old, _ := t.rootUnsafe()
+	from, levels := len(t.nodes[0]), len(t.nodes)
 	// append leaves at level 0
-	t.nodes[0] = append(t.nodes[0], leaves...)
+	for i, leaf := range leaves {
+		t.indexAdd(leaf, from+i)
+	}
+	t.appendLeaves(leaves)
+	if err := t.rehashFromContext(ctx, from, newProgress(progress, from, len(leaves))); err != nil {
+		t.truncate(from, levels)
+		return err
+	}
 
+	t.markDirty()
+	t.recordRoot()
+	t.notify(old, func() []int { return indexRange(from, len(t.nodes[0])) })
+	return nil
+}
+
+// rehashFrom recomputes the internal nodes that depend on the leaves from
+// index onwards, adding the levels required by the current leaf count.
+func (t *LeanIMT[N]) rehashFrom(index int) {
+	_ = t.rehashFromContext(context.Background(), index, nil)
+}
+
+// rehashFromContext is rehashFrom checking ctx between hashing steps. On
+// error the levels are left partially computed.
+func (t *LeanIMT[N]) rehashFromContext(ctx context.Context, index int, p *progressReporter) error {
 	// add necessary new levels
 	newLevels := ceilLog2(len(t.nodes[0])) - (len(t.nodes) - 1)
 	for range newLevels {
@@ -229,45 +320,56 @@ func (t *LeanIMT[N]) InsertMany(leaves []N) error {
 	}
 
 	// compute parents level by level
+	startIndex := index >> 1
 	for level := 0; level < len(t.nodes)-1; level++ {
 		numNodes := (len(t.nodes[level]) + 1) / 2 // ceil
-		for index := startIndex; index < numNodes; index++ {
-			li := index * 2
-			ri := li + 1
-
-			left := t.nodes[level][li]
-			var parent N
-			if ri < len(t.nodes[level]) {
-				right := t.nodes[level][ri]
-				parent = t.hash(left, right)
-			} else {
-				parent = left
-			}
-			ensureIndex(&t.nodes[level+1], index)
-			t.nodes[level+1][index] = parent
+		t.unshare(level+1, startIndex)
+		ensureIndex(&t.nodes[level+1], numNodes-1)
+		if err := t.hashLevel(ctx, level, t.nodes[level], t.nodes[level+1], startIndex, numNodes, p); err != nil {
+			return err
 		}
 		startIndex >>= 1
 	}
-
-	t.markDirty()
 	return nil
 }
 
+// truncate restores the tree to its first size leaves and levels levels,
+// undoing an interrupted insertion.
+func (t *LeanIMT[N]) truncate(size, levels int) {
+	for i := size; i < len(t.nodes[0]); i++ {
+		t.indexRemove(t.values()[i], i)
+	}
+	if t.leafHash != nil {
+		t.leaves = t.leaves[:size]
+	}
+	t.nodes = t.nodes[:levels]
+	for level := range t.nodes {
+		t.nodes[level] = t.nodes[level][:levelSize(size, level)]
+	}
+	// the last parent of each level may include the removed leaves
+	t.rehashFrom(size)
+}
+
 // Update replaces the leaf at index with newLeaf and updates path to root.
-func (t *LeanIMT[N]) Update(index int, newLeaf N) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+func (t *LeanIMT[N]) Update(index int, newLeaf N) (err error) {
+	op := t.lock(OpUpdate)
+	defer func() { t.unlock(op, 1, 0, err) }()
 	if index < 0 || index >= len(t.nodes[0]) {
 		return errors.New("index is out of range")
 	}
+	old, _ := t.rootUnsafe()
+	leafIndex := index
 
-	node := newLeaf
 	// first level
-	t.nodes[0][index] = node
+	t.indexRemove(t.values()[index], index)
+	node := t.setLeaf(index, newLeaf)
+	t.indexAdd(newLeaf, index)
+	t.markLeafUpdated(index)
 
 	depth := len(t.nodes) - 1
 	for level := 0; level < depth; level++ {
 		if level > 0 {
+			t.unshare(level, index)
 			ensureIndex(&t.nodes[level], index)
 			t.nodes[level][index] = node
 		}
@@ -288,18 +390,21 @@ func (t *LeanIMT[N]) Update(index int, newLeaf N) error {
 	}
 
 	top := depth
+	t.unshare(top, 0)
 	t.nodes[top] = t.nodes[top][:0]
 	t.nodes[top] = append(t.nodes[top], node)
 
 	t.markDirty()
+	t.recordRoot()
+	t.notify(old, func() []int { return []int{leafIndex} })
 	return nil
 }
 
 // UpdateMany updates multiple leaves efficiently in O(n).
 // It validates indices (range and duplicates).
-func (t *LeanIMT[N]) UpdateMany(indices []int, leaves []N) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+func (t *LeanIMT[N]) UpdateMany(indices []int, leaves []N) (err error) {
+	op := t.lock(OpUpdateMany)
+	defer func() { t.unlock(op, len(indices), 0, err) }()
 
 	if indices == nil {
 		return errors.New("parameter 'indices' is not defined")
@@ -326,35 +431,39 @@ func (t *LeanIMT[N]) UpdateMany(indices []int, leaves []N) error {
 		return nil
 	}
 
+	old, _ := t.rootUnsafe()
+
 	// level 0 assignments and track modified parents
 	modified := make(map[int]struct{})
 	for i, idx := range indices {
-		t.nodes[0][idx] = leaves[i]
+		t.indexRemove(t.values()[idx], idx)
+		t.setLeaf(idx, leaves[i])
+		t.indexAdd(leaves[i], idx)
+		t.markLeafUpdated(idx)
 		modified[idx>>1] = struct{}{}
 	}
 
 	// propagate up
 	for level := 1; level <= len(t.nodes)-1; level++ {
 		next := make(map[int]struct{})
+		parents := make([]int, 0, len(modified))
 		for idx := range modified {
-			li := 2 * idx
-			ri := li + 1
-			left := t.nodes[level-1][li]
-			var parent N
-			if ri < len(t.nodes[level-1]) {
-				right := t.nodes[level-1][ri]
-				parent = t.hash(left, right)
-			} else {
-				parent = left
-			}
-			ensureIndex(&t.nodes[level], idx)
-			t.nodes[level][idx] = parent
+			t.unshare(level, idx)
+			parents = append(parents, idx)
 			next[idx>>1] = struct{}{}
 		}
+		children, nodes := t.nodes[level-1], t.nodes[level]
+		t.parallel(0, len(parents), func(from, to int) {
+			for _, idx := range parents[from:to] {
+				nodes[idx] = parentOf(t.hash, children, idx)
+			}
+		})
 		modified = next
 	}
 
 	t.markDirty()
+	t.recordRoot()
+	t.notify(old, func() []int { return slices.Sorted(maps.Keys(seen)) })
 	return nil
 }
 
@@ -416,35 +525,72 @@ func ensureIndex[N any](s *[]N, index int) {
 }
 
 // Load restores the tree from persistent storage.
-// It reads all leaves from the database and rebuilds the tree structure.
+// It reads all leaves from the database and rebuilds the tree structure,
+// unless the tree was created WithNodePersistence and the internal nodes
+// were stored by Sync, in which case they are read instead of rehashed.
 func (t *LeanIMT[N]) Load() error {
+	return t.LoadContext(context.Background(), nil)
+}
+
+// LoadContext is like Load but stops when ctx is done, returning ctx.Err()
+// and leaving the tree unchanged. If progress is not nil it is called
+// periodically while leaves are read and while the tree is rebuilt.
+func (t *LeanIMT[N]) LoadContext(ctx context.Context, progress ProgressFunc) (err error) {
+	op := t.lock(OpLoad)
+	defer func() {
+		loaded := 0
+		if err == nil {
+			loaded = len(t.nodes[0])
+		}
+		t.unlock(op, loaded, 0, err)
+	}()
+
 	if t.db == nil {
 		return errors.New("no database configured for loading")
 	}
 	if t.decoder == nil {
 		return errors.New("no decoder function configured")
 	}
+	if err := t.checkHasher(); err != nil {
+		return err
+	}
+	if err := TreeSchema.Upgrade(t.db); err != nil {
+		return err
+	}
 
 	// Read tree size from metadata
 	sizeBytes, err := t.db.Get([]byte("meta:size"))
 	if err != nil {
 		if err == db.ErrKeyNotFound {
 			// No existing tree, start empty
-			t.nodes = [][]N{make([]N, 0)}
-			return nil
+			t.resetLeaves(make([]N, 0))
+			if err := t.checkRoot(); err != nil {
+				return err
+			}
+			return t.afterLoad()
 		}
 		return err
 	}
 
 	size := decodeInt(sizeBytes)
 	if size == 0 {
-		t.nodes = [][]N{make([]N, 0)}
-		return nil
+		t.resetLeaves(make([]N, 0))
+		if err := t.checkRoot(); err != nil {
+			return err
+		}
+		return t.afterLoad()
 	}
 
 	// Load all leaves
+	p := newProgress(progress, 0, size)
 	leaves := make([]N, size)
 	for i := range size {
+		if i%progressStep == 0 && i > 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			p.leaves(i)
+		}
 		key := []byte("leaf:" + intToString(i))
 		leafBytes, err := t.db.Get(key)
 		if err != nil {
@@ -457,9 +603,25 @@ func (t *LeanIMT[N]) Load() error {
 		leaves[i] = leaf
 	}
 
-	// Rebuild tree structure
-	t.nodes = [][]N{leaves}
-	if err := t.rebuildTree(); err != nil {
+	p.leaves(size)
+
+	// Restore the internal nodes if they were persisted, otherwise
+	// rebuild the tree structure
+	previous, previousLeaves := t.nodes, t.leaves
+	t.resetLeaves(leaves)
+	restored, err := t.loadNodes(ctx)
+	if err == nil && !restored {
+		err = t.rebuildTree(ctx, p)
+	}
+	if err == nil {
+		err = t.checkRoot()
+	}
+	if err != nil {
+		t.nodes, t.leaves = previous, previousLeaves
+		return err
+	}
+	t.nodesSynced = restored
+	if err := t.afterLoad(); err != nil {
 		return err
 	}
 
@@ -467,63 +629,192 @@ func (t *LeanIMT[N]) Load() error {
 	return nil
 }
 
+// checkRoot compares the root with the one given WithExpectedRoot, if any.
+func (t *LeanIMT[N]) checkRoot() error {
+	if t.expectedRoot == nil {
+		return nil
+	}
+	if root, ok := t.rootUnsafe(); !ok || !t.equal(root, *t.expectedRoot) {
+		return ErrRootMismatch
+	}
+	return nil
+}
+
+// afterLoad rebuilds the state derived from the loaded leaves.
+func (t *LeanIMT[N]) afterLoad() error {
+	t.shared = nil // loaded levels are never referenced by snapshots
+	t.synced = len(t.nodes[0])
+	t.updated = nil
+	t.rebuildIndex()
+	if err := t.loadRootHistory(); err != nil {
+		return err
+	}
+	t.seedRootHistory()
+	return nil
+}
+
 // Sync persists the current tree state to disk atomically.
-// Only the leaves are stored; intermediate nodes are computed on load.
+// Only the leaves are stored; intermediate nodes are computed on load,
+// unless the tree was created WithNodePersistence.
+// Sync is incremental: it writes the leaves appended or updated since the
+// previous Sync, plus the metadata, in a single transaction.
 func (t *LeanIMT[N]) Sync() error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	return t.SyncContext(context.Background(), nil)
+}
+
+// SyncContext is like Sync but stops when ctx is done, returning ctx.Err()
+// without writing anything. If progress is not nil it is called periodically
+// while leaves are written.
+func (t *LeanIMT[N]) SyncContext(ctx context.Context, progress ProgressFunc) (err error) {
+	op := t.lock(OpSync)
+	tx, written := &bytesTx{}, 0
+	defer func() {
+		if err != nil {
+			written, tx.written = 0, 0
+		}
+		t.unlock(op, written, tx.written, err)
+	}()
+
+	written, err = t.syncUnsafe(ctx, progress, tx, false)
+	return err
+}
 
+// syncUnsafe writes the changes since the last Sync through tx, which it
+// opens on t.db, and returns the number of leaves written. If replace is set
+// the stored tree is being replaced, so its root history is removed even if
+// t keeps none. The caller must hold the write lock.
+func (t *LeanIMT[N]) syncUnsafe(ctx context.Context, progress ProgressFunc, tx *bytesTx, replace bool) (written int, err error) {
 	if t.db == nil {
-		return nil // no-op for in-memory trees
+		return 0, nil // no-op for in-memory trees
 	}
 	if t.encoder == nil {
-		return errors.New("no encoder function configured")
+		return 0, errors.New("no encoder function configured")
 	}
-	if !t.dirty {
-		return nil // no changes to sync
+	if !t.dirty && (!t.persistNodes || t.nodesSynced) {
+		return 0, nil // no changes to sync
 	}
 
-	tx := t.db.WriteTx()
+	tx.WriteTx = t.db.WriteTx()
 	defer tx.Discard()
 
 	currentSize := len(t.nodes[0]) // Use direct access instead of Size()
 
-	// Write all current leaves
-	for i, leaf := range t.nodes[0] {
-		key := []byte("leaf:" + intToString(i))
-		value, err := t.encoder(leaf)
-		if err != nil {
-			return err
+	previousSize, err := t.storedSize()
+	if err != nil {
+		return 0, err
+	}
+	if previousSize != t.synced {
+		// Storage was modified outside this tree (e.g. reset), so the
+		// persisted leaves can't be trusted: rewrite all of them.
+		t.synced = 0
+		t.updated = nil
+	}
+
+	// Write leaves updated since the last sync, then the appended ones
+	p := newProgress(progress, 0, len(t.updated)+currentSize-t.synced)
+	write := func(i int) error {
+		if written%progressStep == 0 && written > 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			p.leaves(written)
 		}
-		if err := tx.Set(key, value); err != nil {
-			return err
+		written++
+		return t.writeLeaf(tx, i)
+	}
+	for i := range t.updated {
+		if err := write(i); err != nil {
+			return written, err
 		}
 	}
+	for i := t.synced; i < currentSize; i++ {
+		if err := write(i); err != nil {
+			return written, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return written, err
+	}
+	p.leaves(written)
 
 	// Clean up any leaves beyond current size
 	// This handles the case where the tree has shrunk
-	if err := t.cleanupStaleLeaves(tx, currentSize); err != nil {
-		return err
+	if err := t.cleanupStaleLeaves(tx, currentSize, previousSize); err != nil {
+		return written, err
+	}
+
+	if t.persistNodes {
+		if err := t.writeNodes(tx, previousSize); err != nil {
+			return written, err
+		}
+	} else if err := tx.Delete([]byte("meta:nodes")); err != nil {
+		// stored nodes, if any, no longer match the leaves
+		return written, err
+	}
+
+	if err := t.writeRootHistory(tx, replace); err != nil {
+		return written, err
 	}
 
 	// Update metadata
 	sizeBytes := encodeInt(currentSize)
 	if err := tx.Set([]byte("meta:size"), sizeBytes); err != nil {
-		return err
+		return written, err
 	}
 
 	// Set version for future migrations
-	if err := tx.Set([]byte("meta:version"), []byte("1")); err != nil {
-		return err
+	if err := tx.Set([]byte("meta:version"), encodeInt(SchemaVersion)); err != nil {
+		return written, err
+	}
+	if t.hasherID != "" {
+		if err := tx.Set([]byte("meta:hasher"), []byte(t.hasherID)); err != nil {
+			return written, err
+		}
 	}
 
 	// Commit atomically
 	if err := tx.Commit(); err != nil {
-		return err
+		return written, err
 	}
 
 	t.dirty = false
-	return nil
+	t.synced = currentSize
+	t.updated = nil
+	t.nodesSynced = t.persistNodes
+	return written, nil
+}
+
+// writeLeaf stores the leaf at index i in tx.
+func (t *LeanIMT[N]) writeLeaf(tx db.WriteTx, i int) error {
+	value, err := t.encoder(t.values()[i])
+	if err != nil {
+		return err
+	}
+	return tx.Set([]byte("leaf:"+intToString(i)), value)
+}
+
+// storedSize returns the number of leaves recorded in storage, 0 if none.
+func (t *LeanIMT[N]) storedSize() (int, error) {
+	sizeBytes, err := t.db.Get([]byte("meta:size"))
+	if err != nil {
+		if err == db.ErrKeyNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return decodeInt(sizeBytes), nil
+}
+
+// markLeafUpdated records that an already persisted leaf was modified.
+// Leaves appended after the last Sync are tracked by size alone.
+func (t *LeanIMT[N]) markLeafUpdated(i int) {
+	if t.db == nil || i >= t.synced {
+		return
+	}
+	if t.updated == nil {
+		t.updated = make(map[int]struct{})
+	}
+	t.updated[i] = struct{}{}
 }
 
 // Close ensures all changes are synced and closes the database connection.
@@ -537,8 +828,63 @@ func (t *LeanIMT[N]) Close() error {
 	return nil
 }
 
-// rebuildTree reconstructs the internal tree structure from leaves.
-func (t *LeanIMT[N]) rebuildTree() error {
+// Persist attaches storage to an in-memory tree, such as one returned by
+// Import, ImportFrom or ReadBinary, and writes the whole tree to it in a
+// single transaction, replacing any tree stored there along with its root
+// history. Afterwards the tree behaves as one opened with New on storage, so
+// Sync and Close persist its changes. It fails with ErrHasherMismatch if storage holds a tree built with
+// a different hasher, and with a SchemaError if it was written by a newer
+// version of this package. On error the tree is left in memory, unchanged.
+func (t *LeanIMT[N]) Persist(storage db.Database, encoder func(N) ([]byte, error), decoder func([]byte) (N, error)) error {
+	return t.PersistContext(context.Background(), storage, encoder, decoder, nil)
+}
+
+// PersistContext is like Persist but stops when ctx is done, returning
+// ctx.Err() without writing anything. If progress is not nil it is called
+// periodically while leaves are written.
+func (t *LeanIMT[N]) PersistContext(ctx context.Context, storage db.Database, encoder func(N) ([]byte, error), decoder func([]byte) (N, error), progress ProgressFunc) (err error) {
+	if storage == nil {
+		return errors.New("parameter 'storage' is not defined")
+	}
+	if encoder == nil || decoder == nil {
+		return errors.New("encoder and decoder functions are required when using persistent storage")
+	}
+
+	op := t.lock(OpSync)
+	tx, written := &bytesTx{}, 0
+	defer func() {
+		if err != nil {
+			written, tx.written = 0, 0
+		}
+		t.unlock(op, written, tx.written, err)
+	}()
+
+	if t.db != nil {
+		return errors.New("tree already has persistent storage")
+	}
+	if err := checkStoredHasher(storage, t.hasherID); err != nil {
+		return err
+	}
+	if err := TreeSchema.Upgrade(storage); err != nil {
+		return err
+	}
+
+	// write every leaf and node, restoring the sync state on failure
+	prevEncoder, prevDecoder := t.encoder, t.decoder
+	synced, updated, nodesSynced, dirty := t.synced, t.updated, t.nodesSynced, t.dirty
+	t.db, t.encoder, t.decoder = storage, encoder, decoder
+	t.synced, t.updated, t.nodesSynced, t.dirty = 0, nil, false, true
+	if written, err = t.syncUnsafe(ctx, progress, tx, true); err != nil {
+		t.db, t.encoder, t.decoder = nil, prevEncoder, prevDecoder
+		t.synced, t.updated, t.nodesSynced, t.dirty = synced, updated, nodesSynced, dirty
+		return err
+	}
+	return nil
+}
+
+// rebuildTree reconstructs the internal tree structure from leaves, checking
+// ctx between hashing steps.
+func (t *LeanIMT[N]) rebuildTree(ctx context.Context, p *progressReporter) error {
 	if len(t.nodes[0]) == 0 {
 		return nil
 	}
@@ -558,38 +904,19 @@ func (t *LeanIMT[N]) rebuildTree() error {
 	for level := 0; level < depth; level++ {
 		currentLevel := t.nodes[level]
 		numParents := (len(currentLevel) + 1) / 2
-		t.nodes[level+1] = make([]N, numParents)
-
-		for i := 0; i < numParents; i++ {
-			leftIdx := i * 2
-			rightIdx := leftIdx + 1
-
-			if rightIdx < len(currentLevel) {
-				// Both children exist
-				t.nodes[level+1][i] = t.hash(currentLevel[leftIdx], currentLevel[rightIdx])
-			} else {
-				// Only left child exists
-				t.nodes[level+1][i] = currentLevel[leftIdx]
-			}
+		parents := make([]N, numParents)
+		if err := t.hashLevel(ctx, level, currentLevel, parents, 0, numParents, p); err != nil {
+			return err
 		}
+		t.nodes[level+1] = parents
 	}
 
 	return nil
 }
 
-// cleanupStaleLeaves removes leaf entries beyond the current tree size.
-func (t *LeanIMT[N]) cleanupStaleLeaves(tx db.WriteTx, currentSize int) error {
-	// Get the previous size from database to know what to clean up
-	sizeBytes, err := t.db.Get([]byte("meta:size"))
-	if err != nil {
-		if err == db.ErrKeyNotFound {
-			return nil // no previous size, nothing to clean
-		}
-		return err
-	}
-
-	previousSize := decodeInt(sizeBytes)
-
+// cleanupStaleLeaves removes leaf entries beyond the current tree size,
+// up to the previously persisted size.
+func (t *LeanIMT[N]) cleanupStaleLeaves(tx db.WriteTx, currentSize, previousSize int) error {
 	// Delete any leaves beyond current size
 	for i := currentSize; i < previousSize; i++ {
 		key := []byte("leaf:" + intToString(i))
@@ -2,6 +2,7 @@ package leanimt
 
 import (
 	"math/big"
+	"math/rand/v2"
 	"testing"
 )
 
@@ -251,3 +252,176 @@ func TestImportExportBigInt(t *testing.T) {
 		t.Fatalf("imported tree root mismatch")
 	}
 }
+
+func TestKeyIndexMatchesScan(t *testing.T) {
+	indexed, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil, WithKeyIndex(BigIntKey))
+	scanned, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+
+	// duplicated values exercise the lowest-index bookkeeping
+	leaves := []*big.Int{bigInt(0), bigInt(1), bigInt(0), bigInt(2), bigInt(0)}
+	for _, tree := range []*LeanIMT[*big.Int]{indexed, scanned} {
+		if err := tree.InsertMany(leaves); err != nil {
+			t.Fatal(err)
+		}
+		tree.Insert(bigInt(3))
+		if err := tree.Update(0, bigInt(7)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.UpdateMany([]int{2, 3}, []*big.Int{bigInt(7), bigInt(0)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for v := int64(-1); v < 10; v++ {
+		if got, want := indexed.IndexOf(bigInt(v)), scanned.IndexOf(bigInt(v)); got != want {
+			t.Fatalf("IndexOf(%d)=%d, want %d", v, got, want)
+		}
+		if indexed.Has(bigInt(v)) != scanned.Has(bigInt(v)) {
+			t.Fatalf("Has(%d) mismatch", v)
+		}
+	}
+	if idx := indexed.IndexOf(bigInt(0)); idx != 3 {
+		t.Fatalf("index=%d, want=3", idx)
+	}
+	if indexed.Has(bigInt(1)) == false || indexed.Has(bigInt(2)) {
+		t.Fatalf("has() mismatch after updates")
+	}
+}
+
+func TestKeyIndexDuplicates(t *testing.T) {
+	indexed, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil, WithKeyIndex(BigIntKey))
+	scanned, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	rng := rand.New(rand.NewPCG(1, 2))
+	leaves := make([]*big.Int, 200)
+	for i := range leaves {
+		leaves[i] = bigInt(rng.Int64N(4))
+	}
+	for _, tree := range []*LeanIMT[*big.Int]{indexed, scanned} {
+		if err := tree.InsertMany(leaves); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// few distinct values, so most updates move the lowest occurrence
+	for range 2000 {
+		i, v := rng.IntN(len(leaves)), bigInt(rng.Int64N(4))
+		for _, tree := range []*LeanIMT[*big.Int]{indexed, scanned} {
+			if err := tree.Update(i, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for v := range int64(4) {
+			if got, want := indexed.IndexOf(bigInt(v)), scanned.IndexOf(bigInt(v)); got != want {
+				t.Fatalf("IndexOf(%d)=%d, want %d", v, got, want)
+			}
+		}
+	}
+}
+
+func TestBigIntKeyCollisions(t *testing.T) {
+	// 0x2d is '-', the old negative prefix
+	dash := new(big.Int).SetBytes([]byte{0x2d, 0x05})
+	for _, pair := range [][2]*big.Int{
+		{dash, bigInt(-5)},
+		{nil, bigInt(0)},
+		{bigInt(5), bigInt(-5)},
+		{bigInt(0), new(big.Int).SetBytes([]byte{0x00})},
+	} {
+		if same := BigIntKey(pair[0]) == BigIntKey(pair[1]); same != (pair[0] != nil && BigIntEqual(pair[0], pair[1])) {
+			t.Fatalf("BigIntKey(%v) == BigIntKey(%v) is %v", pair[0], pair[1], same)
+		}
+	}
+
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil, WithKeyIndex(BigIntKey))
+	if err := tree.InsertMany([]*big.Int{bigInt(-5), dash}); err != nil {
+		t.Fatal(err)
+	}
+	if tree.IndexOf(dash) != 1 || tree.IndexOf(bigInt(-5)) != 0 {
+		t.Fatal("colliding keys in the leaf index")
+	}
+}
+
+func TestRootHistory(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil, WithRootHistory[*big.Int](3))
+
+	var roots []*big.Int
+	for i := range 5 {
+		tree.Insert(bigInt(int64(i)))
+		r, _ := tree.Root()
+		roots = append(roots, r)
+	}
+
+	// only the last 3 roots are kept
+	for i, r := range roots {
+		if want := i >= 2; tree.IsKnownRoot(r) != want {
+			t.Fatalf("IsKnownRoot(root %d)=%v, want %v", i, !want, want)
+		}
+	}
+	if r, ok := tree.RootAt(4); !ok || r.Cmp(roots[3]) != 0 {
+		t.Fatalf("RootAt(4) mismatch")
+	}
+	if _, ok := tree.RootAt(1); ok {
+		t.Fatalf("RootAt(1) should have been evicted")
+	}
+
+	// a proof against an older root is still acceptable
+	old, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	if err := old.InsertMany([]*big.Int{bigInt(0), bigInt(1), bigInt(2), bigInt(3)}); err != nil {
+		t.Fatal(err)
+	}
+	proof, _ := old.GenerateProof(1)
+	if !tree.VerifyProof(proof) || !tree.IsKnownRoot(proof.Root) {
+		t.Fatalf("proof against a recent root should be accepted")
+	}
+
+	// updates record a new root for the same size
+	if err := tree.Update(0, bigInt(9)); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := tree.Root()
+	if r, ok := tree.RootAt(5); !ok || r.Cmp(current) != 0 {
+		t.Fatalf("RootAt should return the most recent root for a size")
+	}
+	if h := tree.RootHistory(); len(h) != 3 || h[2].Size != 5 {
+		t.Fatalf("unexpected history %v", h)
+	}
+}
+
+func TestWorkersMatchSequential(t *testing.T) {
+	leaves := make([]*big.Int, 10_000)
+	for i := range leaves {
+		leaves[i] = bigInt(int64(i))
+	}
+	var indices []int
+	var updates []*big.Int
+	for i := 0; i < len(leaves); i += 3 {
+		indices = append(indices, i)
+		updates = append(updates, bigInt(int64(i+7)))
+	}
+
+	sequential, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	parallel, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil, WithWorkers[*big.Int](8))
+	for _, tree := range []*LeanIMT[*big.Int]{sequential, parallel} {
+		// first batch leaves an odd level, the second one continues it
+		if err := tree.InsertMany(leaves[:4_097]); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.InsertMany(leaves[4_097:]); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.UpdateMany(indices, updates); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, _ := sequential.Root()
+	if got, _ := parallel.Root(); got.Cmp(want) != 0 {
+		t.Fatalf("parallel root mismatch: %v != %v", got, want)
+	}
+	for _, i := range []int{0, 4_096, 4_097, 9_999} {
+		proof, err := parallel.GenerateProof(i)
+		if err != nil || !sequential.VerifyProof(proof) {
+			t.Fatalf("proof %d from parallel tree rejected", i)
+		}
+	}
+}
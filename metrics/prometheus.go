@@ -0,0 +1,101 @@
+// Package metrics exports the operation statistics of LeanIMT and CensusIMT
+// as Prometheus metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	leanimt "github.com/vocdoni/lean-imt-go"
+)
+
+// Collector is a leanimt.Observer that aggregates the reported operations
+// into Prometheus metrics labelled by operation. It implements
+// prometheus.Collector, so it can be registered directly:
+//
+//	collector := metrics.NewCollector("census")
+//	prometheus.MustRegister(collector)
+//	tree, err := leanimt.New(..., leanimt.WithObserver[*big.Int](collector))
+type Collector struct {
+	duration *prometheus.HistogramVec
+	lockWait *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	hashes   *prometheus.CounterVec
+	leaves   *prometheus.CounterVec
+	bytes    *prometheus.CounterVec
+}
+
+// NewCollector creates a Collector whose metric names are prefixed by
+// namespace, which may be empty.
+func NewCollector(namespace string) *Collector {
+	labels := []string{"op"}
+	return &Collector{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "leanimt",
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of tree operations, including the lock wait.",
+			Buckets:   prometheus.ExponentialBuckets(1e-5, 4, 12),
+		}, labels),
+		lockWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "leanimt",
+			Name:      "lock_wait_seconds",
+			Help:      "Time tree operations spent waiting for the lock.",
+			Buckets:   prometheus.ExponentialBuckets(1e-6, 4, 12),
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "leanimt",
+			Name:      "operation_errors_total",
+			Help:      "Number of tree operations that failed.",
+		}, labels),
+		hashes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "leanimt",
+			Name:      "hashes_total",
+			Help:      "Number of hashes computed by tree operations.",
+		}, labels),
+		leaves: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "leanimt",
+			Name:      "leaves_total",
+			Help:      "Number of leaves processed by tree operations.",
+		}, labels),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "leanimt",
+			Name:      "bytes_written_total",
+			Help:      "Number of bytes written to storage by tree operations.",
+		}, labels),
+	}
+}
+
+// Observe records the statistics of an operation.
+func (c *Collector) Observe(stats leanimt.OpStats) {
+	op := string(stats.Op)
+	c.duration.WithLabelValues(op).Observe(stats.Duration.Seconds())
+	c.lockWait.WithLabelValues(op).Observe(stats.LockWait.Seconds())
+	if stats.Err != nil {
+		c.errors.WithLabelValues(op).Inc()
+	}
+	c.hashes.WithLabelValues(op).Add(float64(stats.Hashes))
+	c.leaves.WithLabelValues(op).Add(float64(stats.Leaves))
+	c.bytes.WithLabelValues(op).Add(float64(stats.BytesWritten))
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics() {
+		m.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.metrics() {
+		m.Collect(ch)
+	}
+}
+
+func (c *Collector) metrics() []prometheus.Collector {
+	return []prometheus.Collector{c.duration, c.lockWait, c.errors, c.hashes, c.leaves, c.bytes}
+}
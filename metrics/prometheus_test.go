@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	leanimt "github.com/vocdoni/lean-imt-go"
+)
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector("test")
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.Observe(leanimt.OpStats{Op: leanimt.OpSync, Duration: time.Second, Leaves: 10, BytesWritten: 300})
+	collector.Observe(leanimt.OpStats{Op: leanimt.OpSync, Duration: time.Second, Err: errors.New("storage")})
+	collector.Observe(leanimt.OpStats{Op: leanimt.OpInsertMany, Hashes: 7, Leaves: 8})
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			key := family.GetName() + "/" + m.GetLabel()[0].GetValue()
+			switch {
+			case m.GetCounter() != nil:
+				values[key] = m.GetCounter().GetValue()
+			case m.GetHistogram() != nil:
+				values[key] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	want := map[string]float64{
+		"test_leanimt_operation_duration_seconds/sync":        2,
+		"test_leanimt_operation_errors_total/sync":            1,
+		"test_leanimt_bytes_written_total/sync":               300,
+		"test_leanimt_leaves_total/sync":                      10,
+		"test_leanimt_hashes_total/insert_many":               7,
+		"test_leanimt_lock_wait_seconds/insert_many":          1,
+		"test_leanimt_operation_duration_seconds/insert_many": 1,
+	}
+	for key, v := range want {
+		if values[key] != v {
+			t.Errorf("%s = %v, want %v", key, values[key], v)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package leanimt
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+)
+
+// MultiProof proves the membership of several leaves against the same root.
+// Siblings shared by the paths of several leaves, or that can be computed
+// from the proven leaves, are included only once or not at all.
+//   - Root: root at the time of proof
+//   - Size: number of leaves of the tree, which determines where LeanIMT
+//     omits missing right siblings
+//   - Indices: proven leaf positions, sorted and without duplicates
+//   - Leaves: the leaf values, in the same order as Indices
+//   - Nodes: the nodes needed to recompute the root, bottom-up and left to
+//     right within each level
+type MultiProof[N any] struct {
+	Root    N
+	Size    int
+	Indices []int
+	Leaves  []N
+	Nodes   []N
+}
+
+// GenerateMultiProof builds a single proof for the leaves at indices. Indices
+// may be given in any order; duplicates are proven once.
+func (t *LeanIMT[N]) GenerateMultiProof(indices []int) (MultiProof[N], error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	proof, err := generateMultiProof(len(t.nodes[0]), matrixNode(t.nodes), indices)
+	if t.leafHash != nil {
+		return multiProofValues(proof, t.leaves, err)
+	}
+	return proof, err
+}
+
+// GenerateMultiProof builds a multi-proof for the leaves at indices against
+// the snapshot root.
+func (s *Snapshot[N]) GenerateMultiProof(indices []int) (MultiProof[N], error) {
+	proof, err := generateMultiProof(len(s.nodes[0]), matrixNode(s.nodes), indices)
+	if s.leafHash != nil {
+		return multiProofValues(proof, s.values, err)
+	}
+	return proof, err
+}
+
+// GenerateMultiProof builds a single proof for the leaves at indices.
+func (t *DiskLeanIMT[N]) GenerateMultiProof(indices []int) (MultiProof[N], error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return generateMultiProof(t.size, t.node, indices)
+}
+
+// VerifyMultiProof verifies a multi-proof against the current tree hash
+// function and leaf hasher.
+func (t *LeanIMT[N]) VerifyMultiProof(proof MultiProof[N]) bool {
+	return VerifyMultiProofWithLeafHasher(proof, t.leafHash, t.hash, t.equal)
+}
+
+// VerifyMultiProof verifies a multi-proof against the tree hash function.
+func (t *DiskLeanIMT[N]) VerifyMultiProof(proof MultiProof[N]) bool {
+	return VerifyMultiProofWith(proof, t.hash, t.eq)
+}
+
+// matrixNode returns a node accessor for an in-memory node matrix.
+func matrixNode[N any](nodes [][]N) func(level, index int) (N, error) {
+	return func(level, index int) (N, error) {
+		return nodes[level][index], nil
+	}
+}
+
+// generateMultiProof builds a multi-proof for a tree with size leaves whose
+// nodes are read through node.
+func generateMultiProof[N any](size int, node func(level, index int) (N, error), indices []int) (MultiProof[N], error) {
+	var empty MultiProof[N]
+	if len(indices) == 0 {
+		return empty, errors.New("there are no indices to prove")
+	}
+	known := slices.Clone(indices)
+	slices.Sort(known)
+	known = slices.Compact(known)
+	if known[0] < 0 {
+		return empty, errLeafOutOfRange(known[0])
+	}
+	if last := known[len(known)-1]; last >= size {
+		return empty, errLeafOutOfRange(last)
+	}
+
+	proof := MultiProof[N]{Size: size, Indices: slices.Clone(known)}
+	for _, i := range known {
+		leaf, err := node(0, i)
+		if err != nil {
+			return empty, err
+		}
+		proof.Leaves = append(proof.Leaves, leaf)
+	}
+
+	depth := ceilLog2(size)
+	for level := 0; level < depth; level++ {
+		width := levelSize(size, level)
+		parents := known[:0]
+		for i := 0; i < len(known); i++ {
+			pos := known[i]
+			if pos&1 == 0 && i+1 < len(known) && known[i+1] == pos+1 {
+				i++ // both children are known
+			} else if sibling := pos ^ 1; sibling < width {
+				v, err := node(level, sibling)
+				if err != nil {
+					return empty, err
+				}
+				proof.Nodes = append(proof.Nodes, v)
+			}
+			parents = append(parents, pos>>1)
+		}
+		known = parents
+	}
+
+	root, err := node(depth, 0)
+	if err != nil {
+		return empty, err
+	}
+	proof.Root = root
+	return proof, nil
+}
+
+// VerifyMultiProofWithLeafHasher verifies a multi-proof of a tree created
+// WithLeafHasher, hashing the proof leaves with leafHash first. A nil
+// leafHash verifies as VerifyMultiProofWith.
+func VerifyMultiProofWithLeafHasher[N any](proof MultiProof[N], leafHash LeafHasher[N], hash Hasher[N], eq Equal[N]) bool {
+	return VerifyMultiProofWith(hashMultiProofLeaves(proof, leafHash), hash, eq)
+}
+
+// VerifyMultiProofWith verifies a multi-proof using the provided hash and
+// equality functions. Every node of the proof must be used.
+func VerifyMultiProofWith[N any](proof MultiProof[N], hash Hasher[N], eq Equal[N]) bool {
+	if hash == nil || proof.Size <= 0 || len(proof.Indices) == 0 || len(proof.Indices) != len(proof.Leaves) {
+		return false
+	}
+	for i, pos := range proof.Indices {
+		if pos < 0 || pos >= proof.Size || (i > 0 && pos <= proof.Indices[i-1]) {
+			return false
+		}
+	}
+
+	positions := slices.Clone(proof.Indices)
+	values := slices.Clone(proof.Leaves)
+	nodes := proof.Nodes
+	depth := ceilLog2(proof.Size)
+	for level := 0; level < depth; level++ {
+		width := levelSize(proof.Size, level)
+		n := 0
+		for i := 0; i < len(positions); i++ {
+			pos, v := positions[i], values[i]
+			switch {
+			case pos&1 == 0 && i+1 < len(positions) && positions[i+1] == pos+1:
+				v = hash(v, values[i+1])
+				i++
+			case pos&1 == 0 && pos+1 >= width:
+				// missing right sibling, the node is promoted
+			case len(nodes) == 0:
+				return false
+			case pos&1 == 0:
+				v = hash(v, nodes[0])
+				nodes = nodes[1:]
+			default:
+				v = hash(nodes[0], v)
+				nodes = nodes[1:]
+			}
+			positions[n], values[n] = pos>>1, v
+			n++
+		}
+		positions, values = positions[:n], values[:n]
+	}
+	if len(nodes) != 0 {
+		return false
+	}
+	if eq != nil {
+		return eq(values[0], proof.Root)
+	}
+	return reflect.DeepEqual(values[0], proof.Root)
+}
+
+// Encode serializes the proof in a compact binary form: the size, the
+// number of leaves, the leaf indices as deltas, and the root, leaves and nodes
+// as length-prefixed values encoded with encoder.
+func (p MultiProof[N]) Encode(encoder func(N) ([]byte, error)) ([]byte, error) {
+	if len(p.Indices) != len(p.Leaves) {
+		return nil, errors.New("indices and leaves lengths differ")
+	}
+	buf := appendUvarint(nil, uint64(p.Size))
+	buf = appendUvarint(buf, uint64(len(p.Indices)))
+	previous := 0
+	for _, i := range p.Indices {
+		if i < previous {
+			return nil, errors.New("indices must be sorted")
+		}
+		buf = appendUvarint(buf, uint64(i-previous))
+		previous = i
+	}
+	buf = appendUvarint(buf, uint64(len(p.Nodes)))
+
+	var err error
+	if buf, err = appendValue(buf, p.Root, encoder); err != nil {
+		return nil, err
+	}
+	for _, v := range p.Leaves {
+		if buf, err = appendValue(buf, v, encoder); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range p.Nodes {
+		if buf, err = appendValue(buf, v, encoder); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// DecodeMultiProof parses a proof serialized by MultiProof.Encode.
+func DecodeMultiProof[N any](data []byte, decoder func([]byte) (N, error)) (MultiProof[N], error) {
+	var empty MultiProof[N]
+	r := &byteReader{data: data}
+	size, err := r.uvarint()
+	if err != nil {
+		return empty, err
+	}
+	count, err := r.count()
+	if err != nil {
+		return empty, err
+	}
+	p := MultiProof[N]{Size: int(size), Indices: make([]int, count)}
+	previous := uint64(0)
+	for i := range p.Indices {
+		delta, err := r.uvarint()
+		if err != nil {
+			return empty, err
+		}
+		previous += delta
+		if previous >= size {
+			return empty, errLeafOutOfRange(int(previous))
+		}
+		p.Indices[i] = int(previous)
+	}
+	nodes, err := r.count()
+	if err != nil {
+		return empty, err
+	}
+
+	if p.Root, err = readValue(r, decoder); err != nil {
+		return empty, err
+	}
+	p.Leaves = make([]N, count)
+	for i := range p.Leaves {
+		if p.Leaves[i], err = readValue(r, decoder); err != nil {
+			return empty, err
+		}
+	}
+	p.Nodes = make([]N, nodes)
+	for i := range p.Nodes {
+		if p.Nodes[i], err = readValue(r, decoder); err != nil {
+			return empty, err
+		}
+	}
+	if len(r.data) != 0 {
+		return empty, errors.New("trailing data after multi-proof")
+	}
+	return p, nil
+}
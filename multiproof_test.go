@@ -0,0 +1,105 @@
+package leanimt
+
+import (
+	"math/big"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestMultiProof(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for size := 1; size <= 33; size++ {
+		tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+		for i := range size {
+			tree.Insert(bigInt(int64(i + 100)))
+		}
+		for range 8 {
+			var indices []int
+			for i := range size {
+				if rng.IntN(3) == 0 {
+					indices = append(indices, i)
+				}
+			}
+			if len(indices) == 0 {
+				indices = []int{size - 1}
+			}
+			rng.Shuffle(len(indices), func(i, j int) { indices[i], indices[j] = indices[j], indices[i] })
+
+			proof, err := tree.GenerateMultiProof(indices)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tree.VerifyMultiProof(proof) {
+				t.Fatalf("size %d: valid multi-proof for %v rejected", size, indices)
+			}
+			siblings := 0
+			for _, i := range indices {
+				p, _ := tree.GenerateProof(i)
+				siblings += len(p.Siblings)
+			}
+			if len(proof.Nodes) > siblings {
+				t.Fatalf("size %d: multi-proof has %d nodes, single proofs %d", size, len(proof.Nodes), siblings)
+			}
+
+			tampered := proof
+			tampered.Leaves = append([]*big.Int{bigInt(7)}, proof.Leaves[1:]...)
+			if tree.VerifyMultiProof(tampered) {
+				t.Fatalf("size %d: tampered leaf accepted", size)
+			}
+			extra := proof
+			extra.Nodes = append(append([]*big.Int{}, proof.Nodes...), bigInt(1))
+			if tree.VerifyMultiProof(extra) {
+				t.Fatalf("size %d: proof with unused node accepted", size)
+			}
+		}
+	}
+}
+
+func TestMultiProofSharedSiblings(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	for i := range 8 {
+		tree.Insert(bigInt(int64(i)))
+	}
+	// leaves 0 and 1 share every sibling above the first level
+	proof, err := tree.GenerateMultiProof([]int{1, 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof.Indices) != 2 || len(proof.Nodes) != 2 {
+		t.Fatalf("unexpected proof shape: %d indices, %d nodes", len(proof.Indices), len(proof.Nodes))
+	}
+	if _, err := tree.GenerateMultiProof([]int{0, 8}); err == nil {
+		t.Fatal("out of range index should fail")
+	}
+	if _, err := tree.GenerateMultiProof(nil); err == nil {
+		t.Fatal("empty indices should fail")
+	}
+}
+
+func TestMultiProofEncoding(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	for i := range 21 {
+		tree.Insert(bigInt(int64(i * 1000)))
+	}
+	proof, _ := tree.GenerateMultiProof([]int{2, 3, 9, 20})
+
+	data, err := proof.Encode(bigIntEncoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeMultiProof(data, bigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tree.VerifyMultiProof(decoded) {
+		t.Fatal("decoded multi-proof rejected")
+	}
+	if decoded.Size != 21 || len(decoded.Indices) != 4 || decoded.Indices[3] != 20 {
+		t.Fatalf("unexpected decoded proof %+v", decoded)
+	}
+	for n := range len(data) {
+		if _, err := DecodeMultiProof(data[:n], bigIntDecoder); err == nil {
+			t.Fatalf("truncated encoding (%d bytes) accepted", n)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package leanimt
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/vocdoni/davinci-node/db"
+)
+
+// ErrStoredRootMismatch is returned by Load when the persisted internal nodes
+// do not match the persisted root.
+var ErrStoredRootMismatch = errors.New("stored nodes do not match the stored root")
+
+// ErrStoredNodeMismatch is returned by Load, for trees created
+// WithNodeVerification, when a persisted node is not the hash of its children.
+var ErrStoredNodeMismatch = errors.New("stored node does not match its children")
+
+// levelSize returns the number of nodes at level in a tree with size leaves.
+func levelSize(size, level int) int {
+	if size == 0 || level > ceilLog2(size) {
+		return 0
+	}
+	return (size + (1 << level) - 1) >> level
+}
+
+// nodeKey returns the storage key of the internal node at (level, index).
+func nodeKey(level, index int) []byte {
+	return []byte("node:" + intToString(level) + ":" + intToString(index))
+}
+
+// writeNodes stores the internal nodes modified since the last Sync, the root
+// and the node marker in tx. It requires the leaf tracking state (synced and
+// updated) of the ongoing Sync, and previousSize to clean up stale nodes.
+func (t *LeanIMT[N]) writeNodes(tx db.WriteTx, previousSize int) error {
+	currentSize := len(t.nodes[0])
+	from := t.synced
+	if !t.nodesSynced {
+		from = 0
+	}
+
+	for level := 1; level < len(t.nodes); level++ {
+		start := from >> level
+		// ancestors of updated leaves that precede the appended range
+		ancestors := make(map[int]struct{})
+		if from > 0 {
+			for i := range t.updated {
+				if p := i >> level; p < start {
+					ancestors[p] = struct{}{}
+				}
+			}
+		}
+		for i := range ancestors {
+			if err := t.writeNode(tx, level, i); err != nil {
+				return err
+			}
+		}
+		for i := start; i < len(t.nodes[level]); i++ {
+			if err := t.writeNode(tx, level, i); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove nodes beyond the current size
+	for level := 1; level <= ceilLog2(previousSize); level++ {
+		for i := levelSize(currentSize, level); i < levelSize(previousSize, level); i++ {
+			if err := tx.Delete(nodeKey(level, i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if root, ok := t.rootUnsafe(); ok {
+		value, err := t.encoder(root)
+		if err != nil {
+			return err
+		}
+		if err := tx.Set([]byte("meta:root"), value); err != nil {
+			return err
+		}
+	}
+	return tx.Set([]byte("meta:nodes"), encodeInt(currentSize))
+}
+
+// writeNode stores the internal node at (level, i) in tx.
+func (t *LeanIMT[N]) writeNode(tx db.WriteTx, level, i int) error {
+	value, err := t.encoder(t.nodes[level][i])
+	if err != nil {
+		return err
+	}
+	return tx.Set(nodeKey(level, i), value)
+}
+
+// loadNodes restores the internal levels of a tree whose leaves are already
+// loaded in t.nodes[0]. It returns false if node persistence is disabled or
+// the stored nodes don't belong to the stored leaves, in which case the tree
+// must be rebuilt. The restored root is checked against the stored root and
+// against the hash of its children, and every other node too if verifyNodes
+// is set.
+func (t *LeanIMT[N]) loadNodes(ctx context.Context) (bool, error) {
+	if !t.persistNodes {
+		return false, nil
+	}
+	size := len(t.nodes[0])
+	marker, err := t.db.Get([]byte("meta:nodes"))
+	if err != nil {
+		if err == db.ErrKeyNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if decodeInt(marker) != size {
+		return false, nil
+	}
+
+	depth := ceilLog2(size)
+	nodes := make([][]N, depth+1)
+	nodes[0] = t.nodes[0]
+	for level := 1; level <= depth; level++ {
+		nodes[level] = make([]N, levelSize(size, level))
+		for i := range nodes[level] {
+			if i%progressStep == 0 {
+				if err := ctx.Err(); err != nil {
+					return false, err
+				}
+			}
+			value, err := t.db.Get(nodeKey(level, i))
+			if err == db.ErrKeyNotFound {
+				return false, nil // incomplete nodes, rebuild instead
+			}
+			if err != nil {
+				return false, err
+			}
+			if nodes[level][i], err = t.decoder(value); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	rootBytes, err := t.db.Get([]byte("meta:root"))
+	if err == db.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	storedRoot, err := t.decoder(rootBytes)
+	if err != nil {
+		return false, err
+	}
+	root, _ := rootOf(nodes)
+	if !t.equal(root, storedRoot) {
+		return false, ErrStoredRootMismatch
+	}
+	if depth > 0 {
+		children := nodes[depth-1]
+		if !t.equal(t.hash(children[0], children[1]), root) {
+			return false, ErrStoredRootMismatch
+		}
+	}
+
+	if t.verifyNodes {
+		if err := t.checkNodes(ctx, nodes); err != nil {
+			return false, err
+		}
+	}
+
+	t.nodes = nodes
+	return true, nil
+}
+
+// checkNodes checks that every internal node is the hash of its children,
+// using t.workers goroutines per level.
+func (t *LeanIMT[N]) checkNodes(ctx context.Context, nodes [][]N) error {
+	for level := 1; level < len(nodes); level++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var bad atomic.Bool
+		children, parents := nodes[level-1], nodes[level]
+		t.parallel(0, len(parents), func(from, to int) {
+			for i := from; i < to && !bad.Load(); i++ {
+				if !t.equal(parentOf(t.hash, children, i), parents[i]) {
+					bad.Store(true)
+				}
+			}
+		})
+		if bad.Load() {
+			return ErrStoredNodeMismatch
+		}
+	}
+	return nil
+}
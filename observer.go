@@ -0,0 +1,178 @@
+package leanimt
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/vocdoni/davinci-node/db"
+)
+
+// Op names an operation reported to an Observer.
+type Op string
+
+// Operations reported by LeanIMT.
+const (
+	OpInsert        Op = "insert"
+	OpInsertMany    Op = "insert_many"
+	OpUpdate        Op = "update"
+	OpUpdateMany    Op = "update_many"
+	OpGenerateProof Op = "generate_proof"
+	OpVerifyProof   Op = "verify_proof"
+	OpSync          Op = "sync"
+	OpLoad          Op = "load"
+)
+
+// OpStats describes a finished operation.
+type OpStats struct {
+	Op    Op
+	Start time.Time
+	// Duration is the total time of the operation, including LockWait.
+	Duration time.Duration
+	// LockWait is the time spent waiting for the tree lock.
+	LockWait time.Duration
+	// Hashes is the number of hashes computed by the tree during the
+	// operation. Proofs verified concurrently by the same tree are included.
+	Hashes int64
+	// Leaves is the number of leaves inserted, updated, proven, loaded or
+	// written by the operation.
+	Leaves int
+	// BytesWritten is the size of the keys and values written to storage.
+	BytesWritten int64
+	Err          error
+}
+
+// Observer receives the statistics of every tree operation, for metrics or
+// tracing. Observe is called synchronously once the operation released the
+// tree lock, possibly from several goroutines at once, so implementations
+// must be safe for concurrent use and return quickly.
+type Observer interface {
+	Observe(OpStats)
+}
+
+// ObserverFunc adapts a function to the Observer interface.
+type ObserverFunc func(OpStats)
+
+// Observe calls f(stats).
+func (f ObserverFunc) Observe(stats OpStats) {
+	f(stats)
+}
+
+// Observers returns an Observer that forwards every report to all observers.
+func Observers(observers ...Observer) Observer {
+	return ObserverFunc(func(stats OpStats) {
+		for _, o := range observers {
+			o.Observe(stats)
+		}
+	})
+}
+
+// SpanHook starts a span for an operation that began at start and returns a
+// function ending it with the operation statistics. It mirrors
+// OpenTelemetry's Tracer.Start and Span.End, so a tracer can be plugged in
+// with trace.WithTimestamp without this package depending on OpenTelemetry.
+type SpanHook func(op Op, start time.Time) (end func(OpStats))
+
+// SpanObserver turns a SpanHook into an Observer.
+func SpanObserver(hook SpanHook) Observer {
+	return ObserverFunc(func(stats OpStats) {
+		if end := hook(stats.Op, stats.Start); end != nil {
+			end(stats)
+		}
+	})
+}
+
+// WithObserver reports the statistics of every operation to o. The tree
+// hasher is wrapped to count hashes.
+func WithObserver[N any](o Observer) Option[N] {
+	return func(t *LeanIMT[N]) {
+		t.observer = o
+		if o == nil || t.hashes != nil {
+			return
+		}
+		hash, count := t.hash, new(atomic.Int64)
+		t.hashes = count
+		t.hash = func(a, b N) N {
+			count.Add(1)
+			return hash(a, b)
+		}
+	}
+}
+
+// opState tracks an operation between lock and unlock.
+type opState struct {
+	op     Op
+	write  bool
+	start  time.Time
+	wait   time.Duration
+	hashes int64
+}
+
+// lock acquires the write lock for op, timing the wait if observed.
+func (t *LeanIMT[N]) lock(op Op) opState {
+	return t.acquire(op, true)
+}
+
+// rlock acquires the read lock for op, timing the wait if observed.
+func (t *LeanIMT[N]) rlock(op Op) opState {
+	return t.acquire(op, false)
+}
+
+// acquire takes the read or write lock for op.
+func (t *LeanIMT[N]) acquire(op Op, write bool) opState {
+	s := opState{op: op, write: write}
+	if t.observer != nil {
+		s.start = time.Now()
+	}
+	if write {
+		t.mu.Lock()
+	} else {
+		t.mu.RLock()
+	}
+	if t.observer != nil {
+		s.wait = time.Since(s.start)
+		s.hashes = t.hashes.Load()
+	}
+	return s
+}
+
+// unlock releases the lock taken for s and reports the operation.
+func (t *LeanIMT[N]) unlock(s opState, leaves int, written int64, err error) {
+	var hashes int64
+	if t.observer != nil {
+		hashes = t.hashes.Load() - s.hashes
+	}
+	if s.write {
+		t.mu.Unlock()
+	} else {
+		t.mu.RUnlock()
+	}
+	if t.observer != nil {
+		t.observer.Observe(OpStats{
+			Op:           s.op,
+			Start:        s.start,
+			Duration:     time.Since(s.start),
+			LockWait:     s.wait,
+			Hashes:       hashes,
+			Leaves:       leaves,
+			BytesWritten: written,
+			Err:          err,
+		})
+	}
+}
+
+// bytesTx counts the bytes set through a write transaction.
+type bytesTx struct {
+	db.WriteTx
+	written int64
+}
+
+// Set stores key and value, counting their size.
+func (tx *bytesTx) Set(key, value []byte) error {
+	tx.written += int64(len(key) + len(value))
+	return tx.WriteTx.Set(key, value)
+}
+
+// Unwrap returns the wrapped transaction.
+func (tx *bytesTx) Unwrap() db.WriteTx {
+	return tx.WriteTx
+}
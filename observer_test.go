@@ -0,0 +1,124 @@
+package leanimt
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
+)
+
+// recorder collects the reported operations.
+type recorder struct {
+	mu    sync.Mutex
+	stats []OpStats
+}
+
+func (r *recorder) Observe(stats OpStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats = append(r.stats, stats)
+}
+
+func (r *recorder) last() OpStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats[len(r.stats)-1]
+}
+
+func TestObserverReportsOperations(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	tree, _ := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder, WithObserver[*big.Int](rec))
+
+	tree.Insert(bigInt(1))
+	if s := rec.last(); s.Op != OpInsert || s.Hashes != 0 || s.Leaves != 1 || s.Err != nil {
+		t.Fatalf("unexpected insert stats %+v", s)
+	}
+	if err := tree.InsertMany(manyLeaves(2, 7)); err != nil {
+		t.Fatal(err)
+	}
+	// 8 leaves need 4 + 2 + 1 hashes
+	if s := rec.last(); s.Op != OpInsertMany || s.Hashes != 7 || s.Leaves != 7 {
+		t.Fatalf("unexpected insert many stats %+v", s)
+	}
+	if err := tree.Update(0, bigInt(100)); err != nil {
+		t.Fatal(err)
+	}
+	if s := rec.last(); s.Op != OpUpdate || s.Hashes != 3 {
+		t.Fatalf("unexpected update stats %+v", s)
+	}
+	if err := tree.Update(8, bigInt(0)); err == nil {
+		t.Fatal("expected an error updating out of range")
+	}
+	if s := rec.last(); s.Op != OpUpdate || s.Err == nil {
+		t.Fatalf("unexpected failed update stats %+v", s)
+	}
+
+	proof, err := tree.GenerateProof(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := rec.last(); s.Op != OpGenerateProof || s.Hashes != 0 || s.Leaves != 1 {
+		t.Fatalf("unexpected proof stats %+v", s)
+	}
+	if !tree.VerifyProof(proof) {
+		t.Fatal("proof rejected")
+	}
+	if s := rec.last(); s.Op != OpVerifyProof || s.Hashes != 3 {
+		t.Fatalf("unexpected verify stats %+v", s)
+	}
+
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if s := rec.last(); s.Op != OpSync || s.Leaves != 8 || s.BytesWritten == 0 || s.Duration < s.LockWait {
+		t.Fatalf("unexpected sync stats %+v", s)
+	}
+	if err := tree.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if s := rec.last(); s.Op != OpLoad || s.Leaves != 8 || s.Hashes != 7 {
+		t.Fatalf("unexpected load stats %+v", s)
+	}
+}
+
+func TestObserverLockWait(t *testing.T) {
+	rec := &recorder{}
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil, WithObserver[*big.Int](rec))
+
+	tree.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		tree.Insert(bigInt(1))
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	tree.mu.Unlock()
+	<-done
+	if s := rec.last(); s.LockWait < 20*time.Millisecond || s.Duration < s.LockWait {
+		t.Fatalf("lock wait not reported: %+v", s)
+	}
+}
+
+func TestSpanObserver(t *testing.T) {
+	var ended []OpStats
+	hook := func(op Op, start time.Time) func(OpStats) {
+		if op != OpInsert || start.IsZero() {
+			t.Fatalf("unexpected span start %s %v", op, start)
+		}
+		return func(s OpStats) { ended = append(ended, s) }
+	}
+	rec := &recorder{}
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil,
+		WithObserver[*big.Int](Observers(SpanObserver(hook), rec)))
+	tree.Insert(bigInt(1))
+	if len(ended) != 1 || len(rec.stats) != 1 || ended[0].Op != OpInsert {
+		t.Fatal("span not ended")
+	}
+}
@@ -0,0 +1,67 @@
+package leanimt
+
+// Option configures optional LeanIMT features. Options are applied by New,
+// NewWithPebble and Import before any data is loaded, so features that keep
+// derived state (such as the leaf index) are populated from the start.
+type Option[N any] func(*LeanIMT[N])
+
+// WithKeyIndex enables an in-memory leaf index keyed by keyFn, turning IndexOf
+// and Has into constant time lookups. The index is kept in sync by Insert,
+// InsertMany, Update and UpdateMany, and is rebuilt from the leaves on Load.
+//
+// keyFn must return the same key for two leaves if and only if they are equal
+// under the tree's Equal function.
+func WithKeyIndex[N any](keyFn KeyFunc[N]) Option[N] {
+	return func(t *LeanIMT[N]) {
+		t.keyFn = keyFn
+	}
+}
+
+// WithRootHistory keeps the last size roots, together with the tree size at
+// each of them, so proofs generated against a recent root can still be
+// accepted through IsKnownRoot and RootAt. Persistent trees store the history
+// next to the leaves on Sync. A size of 0 or less disables the history.
+func WithRootHistory[N any](size int) Option[N] {
+	return func(t *LeanIMT[N]) {
+		if size <= 0 {
+			t.history = nil
+			return
+		}
+		t.history = newRootHistory[N](size)
+	}
+}
+
+// WithNodePersistence makes Sync also store the internal nodes of the tree
+// and its root, so Load restores the tree without rehashing it. Only the
+// nodes modified since the previous Sync are written. Load checks the
+// restored root against the stored root and the hash of its children, and
+// falls back to rebuilding from the leaves if no nodes were stored.
+//
+// The other stored nodes and the leaves are trusted: if the database is
+// corrupted below the root, Load succeeds and the affected proofs don't
+// verify. Add WithNodeVerification to check every level on Load.
+func WithNodePersistence[N any]() Option[N] {
+	return func(t *LeanIMT[N]) {
+		t.persistNodes = true
+	}
+}
+
+// WithNodeVerification makes Load, for trees created WithNodePersistence,
+// check every restored node against the hash of its children, and fail with
+// ErrStoredNodeMismatch if one doesn't match. It costs as many hashes as
+// rebuilding the tree, but detects storage corruption instead of serving
+// proofs that don't verify.
+func WithNodeVerification[N any]() Option[N] {
+	return func(t *LeanIMT[N]) {
+		t.verifyNodes = true
+	}
+}
+
+// WithExpectedRoot makes Import, ImportVerified and Load fail with
+// ErrRootMismatch unless the resulting tree has the given root, for instance
+// one read from a trusted source such as a smart contract.
+func WithExpectedRoot[N any](root N) Option[N] {
+	return func(t *LeanIMT[N]) {
+		t.expectedRoot = &root
+	}
+}
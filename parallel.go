@@ -0,0 +1,64 @@
+package leanimt
+
+import (
+	"runtime"
+	"sync"
+)
+
+// parallelThreshold is the minimum number of parents a worker is given.
+// Smaller levels are hashed on the calling goroutine.
+const parallelThreshold = 1024
+
+// WithWorkers sets the number of goroutines used by InsertMany, UpdateMany
+// and the rebuild done on Load to hash each level. The parents of a level are
+// split in contiguous ranges, so the resulting roots are identical to the
+// sequential ones. A value of 0 or less uses runtime.GOMAXPROCS(0).
+//
+// The Hasher must be safe for concurrent use; all hashers in this package are.
+func WithWorkers[N any](workers int) Option[N] {
+	return func(t *LeanIMT[N]) {
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		t.workers = workers
+	}
+}
+
+// parallel splits [from, to) in contiguous ranges and calls fn on each of
+// them from up to t.workers goroutines, waiting for all of them to finish.
+func (t *LeanIMT[N]) parallel(from, to int, fn func(from, to int)) {
+	n := to - from
+	workers := min(t.workers, n/parallelThreshold)
+	if workers <= 1 {
+		fn(from, to)
+		return
+	}
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for lo := from; lo < to; lo += chunk {
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			fn(lo, hi)
+		}(lo, min(lo+chunk, to))
+	}
+	wg.Wait()
+}
+
+// hashParents computes parents[i] for i in [from, to) from the children level.
+func hashParents[N any](hash Hasher[N], children, parents []N, from, to int) {
+	for i := from; i < to; i++ {
+		parents[i] = parentOf(hash, children, i)
+	}
+}
+
+// parentOf returns the parent at index i of the children level; when the
+// right child is missing the parent equals the left child.
+func parentOf[N any](hash Hasher[N], children []N, i int) N {
+	li := 2 * i
+	ri := li + 1
+	if ri < len(children) {
+		return hash(children[li], children[ri])
+	}
+	return children[li]
+}
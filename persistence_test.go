@@ -1,11 +1,13 @@
 package leanimt
 
 import (
+	"errors"
 	"math/big"
 	"os"
 	"testing"
 
 	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
 )
 
 // Helper functions for testing persistence with big.Int
@@ -478,3 +480,332 @@ func TestPersistenceErrorHandling(t *testing.T) {
 		t.Fatal("should fail with invalid directory")
 	}
 }
+
+func TestPersistenceKeyIndex(t *testing.T) {
+	tempDir := createTempDir(t)
+
+	tree1, err := NewWithPebble(bigIntHasher, BigIntEqual, bigIntEncoder, bigIntDecoder, tempDir, WithKeyIndex(BigIntKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree1.InsertMany([]*big.Int{bigInt(10), bigInt(20), bigInt(30)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is rebuilt from the stored leaves on load
+	tree2, err := NewWithPebble(bigIntHasher, BigIntEqual, bigIntEncoder, bigIntDecoder, tempDir, WithKeyIndex(BigIntKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tree2.Close() }()
+
+	if idx := tree2.IndexOf(bigInt(30)); idx != 2 {
+		t.Fatalf("index=%d, want=2", idx)
+	}
+	if tree2.Has(bigInt(40)) {
+		t.Fatal("unexpected leaf in loaded index")
+	}
+}
+
+func TestPersistenceRootHistory(t *testing.T) {
+	tempDir := createTempDir(t)
+
+	tree1, err := NewWithPebble(bigIntHasher, BigIntEqual, bigIntEncoder, bigIntDecoder, tempDir, WithRootHistory[*big.Int](4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roots []*big.Int
+	for i := range 6 {
+		tree1.Insert(bigInt(int64(i)))
+		r, _ := tree1.Root()
+		roots = append(roots, r)
+	}
+	if err := tree1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree2, err := NewWithPebble(bigIntHasher, BigIntEqual, bigIntEncoder, bigIntDecoder, tempDir, WithRootHistory[*big.Int](4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tree2.Close() }()
+
+	history := tree2.RootHistory()
+	if len(history) != 4 {
+		t.Fatalf("expected 4 roots, got %d", len(history))
+	}
+	for i, e := range history {
+		if e.Size != i+3 || e.Root.Cmp(roots[i+2]) != 0 {
+			t.Fatalf("history entry %d mismatch", i)
+		}
+	}
+	if !tree2.IsKnownRoot(roots[2]) || tree2.IsKnownRoot(roots[1]) {
+		t.Fatal("unexpected known roots after reload")
+	}
+
+	// Persist replaces the stored history along with the stored tree
+	database, err := metadb.New(db.TypeInMem, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder, WithRootHistory[*big.Int](4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replaced.InsertMany(manyLeaves(1, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaced.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	replacedRoot, _ := replaced.Root()
+	imported, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	if err := imported.InsertMany(manyLeaves(10, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := imported.Persist(database, bigIntEncoder, bigIntDecoder); err != nil {
+		t.Fatal(err)
+	}
+	importedRoot, _ := imported.Root()
+	reopened, err := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder, WithRootHistory[*big.Int](4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.IsKnownRoot(replacedRoot) || !reopened.IsKnownRoot(importedRoot) {
+		t.Fatal("root history of the replaced tree kept by Persist")
+	}
+}
+
+// countingDB wraps a database and counts the keys written by transactions.
+type countingDB struct {
+	db.Database
+	sets int
+}
+
+func (c *countingDB) WriteTx() db.WriteTx {
+	return &countingTx{WriteTx: c.Database.WriteTx(), db: c}
+}
+
+type countingTx struct {
+	db.WriteTx
+	db *countingDB
+}
+
+func (tx *countingTx) Set(key, value []byte) error {
+	tx.db.sets++
+	return tx.WriteTx.Set(key, value)
+}
+
+func TestPersistenceIncrementalSync(t *testing.T) {
+	tempDir := createTempDir(t)
+	database, err := metadb.New(db.TypePebble, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := &countingDB{Database: database}
+
+	tree1, err := New(bigIntHasher, BigIntEqual, counter, bigIntEncoder, bigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := make([]*big.Int, 100)
+	for i := range leaves {
+		leaves[i] = bigInt(int64(i))
+	}
+	if err := tree1.InsertMany(leaves); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree1.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	const metaKeys = 2 // meta:size and meta:version
+	if counter.sets != len(leaves)+metaKeys {
+		t.Fatalf("initial sync wrote %d keys, want %d", counter.sets, len(leaves)+metaKeys)
+	}
+
+	// one update and one insert only write two leaves
+	counter.sets = 0
+	if err := tree1.Update(7, bigInt(700)); err != nil {
+		t.Fatal(err)
+	}
+	tree1.Insert(bigInt(100))
+	if err := tree1.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if counter.sets != 2+metaKeys {
+		t.Fatalf("incremental sync wrote %d keys, want %d", counter.sets, 2+metaKeys)
+	}
+	root1, _ := tree1.Root()
+	if err := tree1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree2, err := NewWithPebble(bigIntHasher, BigIntEqual, bigIntEncoder, bigIntDecoder, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tree2.Close() }()
+	root2, _ := tree2.Root()
+	if tree2.Size() != 101 || root1.Cmp(root2) != 0 {
+		t.Fatal("incrementally synced tree does not match after reload")
+	}
+}
+
+func TestPersistenceNodes(t *testing.T) {
+	tempDir := createTempDir(t)
+	database, err := metadb.New(db.TypePebble, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// count hashes to check the reload does not rehash the tree
+	hashes := 0
+	countingHasher := func(a, b *big.Int) *big.Int {
+		hashes++
+		return bigIntHasher(a, b)
+	}
+
+	tree1, err := New(countingHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder, WithNodePersistence[*big.Int]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := make([]*big.Int, 37)
+	for i := range leaves {
+		leaves[i] = bigInt(int64(i))
+	}
+	if err := tree1.InsertMany(leaves); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree1.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// incremental changes must keep the stored nodes consistent
+	if err := tree1.Update(3, bigInt(300)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree1.InsertMany([]*big.Int{bigInt(37), bigInt(38), bigInt(39)}); err != nil {
+		t.Fatal(err)
+	}
+	root1, _ := tree1.Root()
+	if err := tree1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	hashes = 0
+	tree2, err := NewWithPebble(countingHasher, BigIntEqual, bigIntEncoder, bigIntDecoder, tempDir, WithNodePersistence[*big.Int]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root2, _ := tree2.Root()
+	if root1.Cmp(root2) != 0 {
+		t.Fatal("roots should match after persistence")
+	}
+	if hashes != 1 {
+		t.Fatalf("load computed %d hashes, want only the root check", hashes)
+	}
+
+	// the restored tree keeps working and matches a rebuilt one
+	tree2.Insert(bigInt(40))
+	for i := range 41 {
+		p, err := tree2.GenerateProof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tree2.VerifyProof(p) {
+			t.Fatalf("proof %d did not verify", i)
+		}
+	}
+	if err := tree2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := NewWithPebble(bigIntHasher, BigIntEqual, bigIntEncoder, bigIntDecoder, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rebuilt.Close() }()
+	root3, _ := rebuilt.Root()
+	tree3, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	if err := tree3.InsertMany(rebuilt.Leaves()); err != nil {
+		t.Fatal(err)
+	}
+	root4, _ := tree3.Root()
+	if root3.Cmp(root4) != 0 {
+		t.Fatal("rebuilt root mismatch")
+	}
+}
+
+func TestPersistenceNodesCorrupted(t *testing.T) {
+	tempDir := createTempDir(t)
+	database, err := metadb.New(db.TypePebble, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree1, err := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder, WithNodePersistence[*big.Int]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree1.InsertMany([]*big.Int{bigInt(1), bigInt(2), bigInt(3), bigInt(4), bigInt(5)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree1.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// tamper with a node below the root
+	tx := database.WriteTx()
+	if err := tx.Set(nodeKey(2, 0), []byte{42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree2, err := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder, WithNodePersistence[*big.Int]())
+	if !errors.Is(err, ErrStoredRootMismatch) || tree2 != nil {
+		t.Fatalf("expected stored root mismatch, got %v", err)
+	}
+	_ = database.Close()
+}
+
+func TestPersistenceNodesVerification(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	tree1, err := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder, WithNodePersistence[*big.Int]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree1.InsertMany(manyLeaves(1, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree1.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	open := func(opts ...Option[*big.Int]) (*LeanIMT[*big.Int], error) {
+		return New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder,
+			append(opts, WithNodePersistence[*big.Int]())...)
+	}
+	if _, err := open(WithNodeVerification[*big.Int]()); err != nil {
+		t.Fatal(err)
+	}
+
+	// tamper with a node far from the root, which the root check misses
+	tx := database.WriteTx()
+	if err := tx.Set(nodeKey(1, 3), []byte{42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open(); err != nil {
+		t.Fatalf("unverified load failed: %v", err)
+	}
+	if _, err := open(WithNodeVerification[*big.Int]()); !errors.Is(err, ErrStoredNodeMismatch) {
+		t.Fatalf("expected ErrStoredNodeMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package leanimt
+
+import (
+	"context"
+	"time"
+)
+
+// progressStep is the number of leaves or nodes processed between two
+// cancellation checks and progress reports.
+const progressStep = 1 << 16
+
+// Progress describes the advance of a long operation.
+type Progress struct {
+	// Level is 0 while leaves are read, written or inserted, and the tree
+	// level being hashed afterwards.
+	Level int
+	// Leaves is the number of leaves processed at Level.
+	Leaves int
+	// Total is the number of leaves the operation processes.
+	Total int
+	// Elapsed is the time since the operation started.
+	Elapsed time.Duration
+}
+
+// ProgressFunc receives progress reports. It is called synchronously from the
+// goroutine running the operation, with the tree locked, so it must return
+// quickly and must not call methods of the tree.
+type ProgressFunc func(Progress)
+
+// progressReporter reports the progress of an operation on the leaves
+// [base, base+total).
+type progressReporter struct {
+	fn    ProgressFunc
+	start time.Time
+	base  int
+	total int
+}
+
+// newProgress returns a reporter for an operation on total leaves starting at
+// leaf base. It returns nil if fn is nil.
+func newProgress(fn ProgressFunc, base, total int) *progressReporter {
+	if fn == nil {
+		return nil
+	}
+	return &progressReporter{fn: fn, start: time.Now(), base: base, total: total}
+}
+
+// leaves reports that the leaves up to index end (exclusive) were processed.
+func (p *progressReporter) leaves(end int) {
+	p.nodes(0, end)
+}
+
+// nodes reports that the nodes up to index end (exclusive) of level were
+// computed, which covers the leaves before end << level.
+func (p *progressReporter) nodes(level, end int) {
+	if p == nil {
+		return
+	}
+	done := min(max((end<<level)-p.base, 0), p.total)
+	p.fn(Progress{Level: level, Leaves: done, Total: p.total, Elapsed: time.Since(p.start)})
+}
+
+// hashLevel computes parents[from:to] from the children level in steps,
+// checking ctx and reporting progress between steps.
+func (t *LeanIMT[N]) hashLevel(ctx context.Context, level int, children, parents []N, from, to int, p *progressReporter) error {
+	for lo := from; lo < to; lo += progressStep {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hi := min(lo+progressStep, to)
+		t.parallel(lo, hi, func(from, to int) {
+			hashParents(t.hash, children, parents, from, to)
+		})
+		p.nodes(level+1, hi)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package leanimt
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
+)
+
+func manyLeaves(from, n int) []*big.Int {
+	leaves := make([]*big.Int, n)
+	for i := range leaves {
+		leaves[i] = bigInt(int64(from + i))
+	}
+	return leaves
+}
+
+func TestInsertManyContextCancel(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil, WithKeyIndex(BigIntKey), WithRootHistory[*big.Int](4))
+	if err := tree.InsertMany(manyLeaves(0, 5)); err != nil {
+		t.Fatal(err)
+	}
+	rootBefore, _ := tree.Root()
+	history := len(tree.RootHistory())
+
+	// cancel from the first progress report, after some nodes were hashed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batch := manyLeaves(5, 3*progressStep)
+	err := tree.InsertManyContext(ctx, batch, func(Progress) { cancel() })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if root, _ := tree.Root(); root.Cmp(rootBefore) != 0 || tree.Size() != 5 || tree.Depth() != 3 {
+		t.Fatal("tree modified by a cancelled insertion")
+	}
+	if tree.IndexOf(batch[0]) != -1 || len(tree.RootHistory()) != history {
+		t.Fatal("derived state modified by a cancelled insertion")
+	}
+
+	// the tree keeps working as if the insertion never happened
+	var reports []Progress
+	if err := tree.InsertManyContext(context.Background(), batch, func(p Progress) { reports = append(reports, p) }); err != nil {
+		t.Fatal(err)
+	}
+	fresh, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	if err := fresh.InsertMany(append(manyLeaves(0, 5), batch...)); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := fresh.Root()
+	if got, _ := tree.Root(); got.Cmp(want) != 0 {
+		t.Fatal("root mismatch after a cancelled insertion")
+	}
+	last := reports[len(reports)-1]
+	if last.Level != tree.Depth() || last.Leaves != len(batch) || last.Total != len(batch) {
+		t.Fatalf("unexpected last report %+v", last)
+	}
+}
+
+func TestSyncAndLoadContextCancel(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, _ := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder)
+	if err := tree.InsertMany(manyLeaves(0, 2*progressStep)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tree.SyncContext(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if size, _ := tree.storedSize(); size != 0 {
+		t.Fatal("cancelled Sync wrote to storage")
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second tree with its own leaves keeps them when loading is cancelled
+	other, _ := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder)
+	other.Insert(bigInt(-1))
+	rootBefore, _ := other.Root()
+	if err := other.LoadContext(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if root, _ := other.Root(); root.Cmp(rootBefore) != 0 {
+		t.Fatal("tree modified by a cancelled Load")
+	}
+
+	var levels []int
+	if err := other.LoadContext(context.Background(), func(p Progress) { levels = append(levels, p.Level) }); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := tree.Root()
+	if got, _ := other.Root(); got.Cmp(want) != 0 {
+		t.Fatal("root mismatch after Load")
+	}
+	if levels[0] != 0 || levels[len(levels)-1] != other.Depth() {
+		t.Fatalf("unexpected progress levels %v", levels)
+	}
+}
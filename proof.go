@@ -3,6 +3,7 @@ package leanimt
 import (
 	"errors"
 	"reflect"
+	"time"
 )
 
 // MerkleProof contains the fields needed to verify membership:
@@ -20,32 +21,44 @@ type MerkleProof[N any] struct {
 }
 
 // GenerateProof builds a LeanIMT proof for the leaf at index.
-func (t *LeanIMT[N]) GenerateProof(index int) (MerkleProof[N], error) {
+// The read lock is held for the whole computation, so the proof is always
+// consistent with a single root.
+func (t *LeanIMT[N]) GenerateProof(index int) (proof MerkleProof[N], err error) {
+	op := t.rlock(OpGenerateProof)
+	defer func() { t.unlock(op, 1, 0, err) }()
+	return generateProof(t.nodes, t.values(), index)
+}
+
+// generateProof builds a LeanIMT proof for the leaf at index from a node
+// matrix and the leaves as inserted, which are level 0 unless the tree has a
+// leaf hasher.
+func generateProof[N any](nodes [][]N, values []N, index int) (MerkleProof[N], error) {
 	var empty MerkleProof[N]
 
-	if index < 0 || index >= t.Size() {
+	if index < 0 || index >= len(nodes[0]) {
 		return empty, errLeafOutOfRange(index)
 	}
 	leafIndex := uint64(index)
+	depth := len(nodes) - 1
 
-	leaf := t.nodes[0][index]
-	siblings := make([]N, 0, t.Depth())
+	leaf := values[index]
+	siblings := make([]N, 0, depth)
 	// Collect path bits for levels where a sibling exists.
-	pathBits := make([]uint8, 0, t.Depth())
+	pathBits := make([]uint8, 0, depth)
 
-	for level := 0; level < t.Depth(); level++ {
+	for level := 0; level < depth; level++ {
 		isRight := (index & 1) == 1
 		var haveSibling bool
 		var sibling N
 
 		if isRight {
 			// left sibling must exist (since current node exists)
-			sibling = t.nodes[level][index-1]
+			sibling = nodes[level][index-1]
 			haveSibling = true
 		} else {
 			ri := index + 1
-			if ri < len(t.nodes[level]) {
-				sibling = t.nodes[level][ri]
+			if ri < len(nodes[level]) {
+				sibling = nodes[level][ri]
 				haveSibling = true
 			}
 		}
@@ -69,7 +82,7 @@ func (t *LeanIMT[N]) GenerateProof(index int) (MerkleProof[N], error) {
 		}
 	}
 
-	root, _ := t.Root()
+	root, _ := rootOf(nodes)
 	return MerkleProof[N]{
 		Root:      root,
 		Leaf:      leaf,
@@ -79,17 +92,42 @@ func (t *LeanIMT[N]) GenerateProof(index int) (MerkleProof[N], error) {
 	}, nil
 }
 
-// VerifyProof verifies a proof against the current tree hash function.
+// VerifyProof verifies a proof against the current tree hash function and
+// leaf hasher.
 func (t *LeanIMT[N]) VerifyProof(proof MerkleProof[N]) bool {
-	return VerifyProofWith(proof, t.hash, t.equal)
+	if t.observer == nil {
+		return VerifyProofWithLeafHasher(proof, t.leafHash, t.hash, t.equal)
+	}
+	start := time.Now()
+	ok := VerifyProofWithLeafHasher(proof, t.leafHash, t.hash, t.equal)
+	t.observer.Observe(OpStats{
+		Op:       OpVerifyProof,
+		Start:    start,
+		Duration: time.Since(start),
+		Hashes:   int64(len(proof.Siblings)),
+		Leaves:   1,
+	})
+	return ok
 }
 
 // VerifyProofWith verifies a proof using the provided hash and equality functions.
+// Proofs of trees created WithLeafHasher must be verified with
+// VerifyProofWithLeafHasher instead.
 func VerifyProofWith[N any](proof MerkleProof[N], hash Hasher[N], eq Equal[N]) bool {
+	return VerifyProofWithLeafHasher(proof, nil, hash, eq)
+}
+
+// VerifyProofWithLeafHasher verifies a proof of a tree created WithLeafHasher:
+// proof.Leaf is hashed with leafHash before walking up the siblings. A nil
+// leafHash verifies as VerifyProofWith.
+func VerifyProofWithLeafHasher[N any](proof MerkleProof[N], leafHash LeafHasher[N], hash Hasher[N], eq Equal[N]) bool {
 	if hash == nil {
 		return false
 	}
 	node := proof.Leaf
+	if leafHash != nil {
+		node = leafHash(node)
+	}
 	for i := 0; i < len(proof.Siblings); i++ {
 		if ((proof.PathBits >> uint(i)) & 1) == 1 {
 			node = hash(proof.Siblings[i], node)
@@ -0,0 +1,167 @@
+package leanimt
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// merkleProofJSON is the layout of zk-kit's LeanIMTMerkleProof.
+type merkleProofJSON struct {
+	Root     json.RawMessage   `json:"root"`
+	Leaf     json.RawMessage   `json:"leaf"`
+	Index    uint64            `json:"index"`
+	Siblings []json.RawMessage `json:"siblings"`
+}
+
+// MarshalJSON encodes the proof with the layout of zk-kit's
+// LeanIMTMerkleProof: {"root", "leaf", "index", "siblings"}. As in zk-kit,
+// "index" holds the packed path bits (PathBits), not LeafIndex, which is not
+// part of the layout. Values implementing encoding.TextMarshaler, such as
+// *big.Int, are written as strings (decimal for *big.Int).
+func (p MerkleProof[N]) MarshalJSON() ([]byte, error) {
+	out := merkleProofJSON{Index: p.PathBits, Siblings: make([]json.RawMessage, len(p.Siblings))}
+	var err error
+	if out.Root, err = marshalValue(p.Root); err != nil {
+		return nil, err
+	}
+	if out.Leaf, err = marshalValue(p.Leaf); err != nil {
+		return nil, err
+	}
+	for i, s := range p.Siblings {
+		if out.Siblings[i], err = marshalValue(s); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a proof in zk-kit's LeanIMTMerkleProof layout. String
+// values are parsed with encoding.TextUnmarshaler when available, so *big.Int
+// accepts both decimal and 0x-prefixed hex strings; other values are decoded
+// with encoding/json. LeafIndex is left to zero as zk-kit does not include it.
+func (p *MerkleProof[N]) UnmarshalJSON(data []byte) error {
+	var in merkleProofJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	if in.Root == nil || in.Leaf == nil {
+		return errors.New("proof root and leaf are required")
+	}
+	proof := MerkleProof[N]{PathBits: in.Index, Siblings: make([]N, len(in.Siblings))}
+	var err error
+	if proof.Root, err = unmarshalValue[N](in.Root); err != nil {
+		return err
+	}
+	if proof.Leaf, err = unmarshalValue[N](in.Leaf); err != nil {
+		return err
+	}
+	for i, s := range in.Siblings {
+		if proof.Siblings[i], err = unmarshalValue[N](s); err != nil {
+			return err
+		}
+	}
+	*p = proof
+	return nil
+}
+
+// marshalValue encodes v as a JSON string if it implements
+// encoding.TextMarshaler, or with encoding/json otherwise.
+func marshalValue[N any](v N) (json.RawMessage, error) {
+	if m, ok := any(v).(encoding.TextMarshaler); ok && !isNil(v) {
+		text, err := m.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(string(text))
+	}
+	return json.Marshal(v)
+}
+
+// unmarshalValue decodes a JSON value written by marshalValue, or by other
+// implementations using hex or decimal strings.
+func unmarshalValue[N any](data json.RawMessage) (N, error) {
+	var v N
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		// N may be a pointer (*big.Int) or have pointer receivers
+		if rt := reflect.TypeFor[N](); rt.Kind() == reflect.Pointer {
+			v = reflect.New(rt.Elem()).Interface().(N)
+			if u, ok := any(v).(encoding.TextUnmarshaler); ok {
+				return v, u.UnmarshalText([]byte(s))
+			}
+		} else if u, ok := any(&v).(encoding.TextUnmarshaler); ok {
+			return v, u.UnmarshalText([]byte(s))
+		}
+	}
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// isNil reports whether v is a nil pointer.
+func isNil[N any](v N) bool {
+	rv := reflect.ValueOf(v)
+	return !rv.IsValid() || (rv.Kind() == reflect.Pointer && rv.IsNil())
+}
+
+// Encode serializes the proof in a compact binary form: the path bits, the
+// leaf index and the number of siblings as varints, followed by the root,
+// the leaf and the siblings as length-prefixed values encoded with encoder.
+func (p MerkleProof[N]) Encode(encoder func(N) ([]byte, error)) ([]byte, error) {
+	buf := appendUvarint(nil, p.PathBits)
+	buf = appendUvarint(buf, p.LeafIndex)
+	buf = appendUvarint(buf, uint64(len(p.Siblings)))
+
+	var err error
+	if buf, err = appendValue(buf, p.Root, encoder); err != nil {
+		return nil, err
+	}
+	if buf, err = appendValue(buf, p.Leaf, encoder); err != nil {
+		return nil, err
+	}
+	for _, v := range p.Siblings {
+		if buf, err = appendValue(buf, v, encoder); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// DecodeMerkleProof parses a proof serialized by MerkleProof.Encode.
+func DecodeMerkleProof[N any](data []byte, decoder func([]byte) (N, error)) (MerkleProof[N], error) {
+	var empty MerkleProof[N]
+	r := &byteReader{data: data}
+	pathBits, err := r.uvarint()
+	if err != nil {
+		return empty, err
+	}
+	leafIndex, err := r.uvarint()
+	if err != nil {
+		return empty, err
+	}
+	count, err := r.count()
+	if err != nil {
+		return empty, err
+	}
+	if count > 64 {
+		return empty, errors.New("too many siblings")
+	}
+
+	p := MerkleProof[N]{PathBits: pathBits, LeafIndex: leafIndex, Siblings: make([]N, count)}
+	if p.Root, err = readValue(r, decoder); err != nil {
+		return empty, err
+	}
+	if p.Leaf, err = readValue(r, decoder); err != nil {
+		return empty, err
+	}
+	for i := range p.Siblings {
+		if p.Siblings[i], err = readValue(r, decoder); err != nil {
+			return empty, err
+		}
+	}
+	if len(r.data) != 0 {
+		return empty, errors.New("trailing data after proof")
+	}
+	return p, nil
+}
@@ -0,0 +1,90 @@
+package leanimt
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestMerkleProofJSON(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	for i := range 5 {
+		tree.Insert(bigInt(int64(i + 1)))
+	}
+	proof, _ := tree.GenerateProof(3)
+
+	data, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var layout map[string]any
+	if err := json.Unmarshal(data, &layout); err != nil {
+		t.Fatal(err)
+	}
+	if len(layout) != 4 || layout["leaf"] != "4" || layout["index"] != float64(proof.PathBits) {
+		t.Fatalf("unexpected layout %s", data)
+	}
+
+	var decoded MerkleProof[*big.Int]
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !tree.VerifyProof(decoded) || decoded.PathBits != proof.PathBits {
+		t.Fatal("decoded proof rejected")
+	}
+
+	// hex strings and plain numbers are accepted too
+	hexProof := `{"root":"0x` + proof.Root.Text(16) + `","leaf":4,"index":` +
+		string(mustJSON(t, proof.PathBits)) + `,"siblings":[`
+	for i, s := range proof.Siblings {
+		if i > 0 {
+			hexProof += ","
+		}
+		hexProof += `"0x` + s.Text(16) + `"`
+	}
+	hexProof += "]}"
+	if err := json.Unmarshal([]byte(hexProof), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !tree.VerifyProof(decoded) {
+		t.Fatal("hex proof rejected")
+	}
+	if err := json.Unmarshal([]byte(`{"root":"zz","leaf":"1","index":0,"siblings":[]}`), &decoded); err == nil {
+		t.Fatal("invalid root accepted")
+	}
+}
+
+func TestMerkleProofEncoding(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	for i := range 9 {
+		tree.Insert(bigInt(int64(i * 7)))
+	}
+	proof, _ := tree.GenerateProof(8)
+
+	data, err := proof.Encode(bigIntEncoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeMerkleProof(data, bigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tree.VerifyProof(decoded) || decoded.LeafIndex != 8 || len(decoded.Siblings) != len(proof.Siblings) {
+		t.Fatalf("unexpected decoded proof %+v", decoded)
+	}
+	if _, err := DecodeMerkleProof(append(data, 0), bigIntDecoder); err == nil {
+		t.Fatal("trailing data accepted")
+	}
+	if _, err := DecodeMerkleProof(data[:len(data)-1], bigIntDecoder); err == nil {
+		t.Fatal("truncated proof accepted")
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,206 @@
+package leanimt
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/vocdoni/davinci-node/db"
+)
+
+// IDs of the hashers registered by this package.
+const (
+	HasherPoseidon            = "poseidon"
+	HasherPoseidon2           = "poseidon2"
+	HasherPoseidon2BLS12377   = "poseidon2-bls12-377"
+	HasherMiMCBN254           = "mimc-bn254"
+	HasherMiMCBLS12377        = "mimc-bls12-377"
+	HasherMiMC7               = "mimc7"
+	HasherMultiPoseidon       = "multiposeidon"
+	HasherSHA256              = "sha256"
+	HasherBlake2b             = "blake2b"
+	HasherKeccak256           = "keccak256"
+	HasherSHA256Fixed         = "sha256-fixed"
+	HasherBlake2bFixed        = "blake2b-fixed"
+	HasherSHA256Bytes32       = "sha256-bytes32"
+	HasherBlake2bBytes32      = "blake2b-bytes32"
+	HasherKeccak256Bytes32    = "keccak256-bytes32"
+	HasherMiMCBN254Element    = "mimc-bn254-element"
+	HasherMiMCBLS12377Element = "mimc-bls12-377-element"
+)
+
+// ErrHasherMismatch is returned when opening a database whose tree was built
+// with a different hasher than the configured one, or with a registered
+// hasher when the configured one isn't registered.
+var ErrHasherMismatch = errors.New("tree was built with a different hasher")
+
+var (
+	hashersMu sync.RWMutex
+	hashers   = make(map[string]any) // ID -> Hasher[N]
+)
+
+func init() {
+	mustRegister(HasherPoseidon, PoseidonHasher)
+	mustRegister(HasherPoseidon2, Poseidon2Hasher)
+	mustRegister(HasherPoseidon2BLS12377, Poseidon2BLS12377Hasher)
+	mustRegister(HasherMiMCBN254, MiMCBN254Hasher)
+	mustRegister(HasherMiMCBLS12377, MiMCBLS12377Hasher)
+	mustRegister(HasherMiMC7, MiMC7Hasher)
+	mustRegister(HasherMultiPoseidon, MultiPoseidonHasher)
+	mustRegister(HasherSHA256, SHA256Hasher)
+	mustRegister(HasherBlake2b, Blake2bHasher)
+	mustRegister(HasherKeccak256, Keccak256Hasher)
+	mustRegister(HasherSHA256Fixed, SHA256FixedHasher)
+	mustRegister(HasherBlake2bFixed, Blake2bFixedHasher)
+	mustRegister(HasherSHA256Bytes32, SHA256Bytes32Hasher)
+	mustRegister(HasherBlake2bBytes32, Blake2bBytes32Hasher)
+	mustRegister(HasherKeccak256Bytes32, Keccak256Bytes32Hasher)
+	mustRegister(HasherMiMCBN254Element, MiMCBN254ElementHasher)
+	mustRegister(HasherMiMCBLS12377Element, MiMCBLS12377ElementHasher)
+}
+
+func mustRegister[N any](id string, hash Hasher[N]) {
+	if err := RegisterHasher(id, hash); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterHasher makes hash available under id to LookupHasher and NewNamed.
+// IDs are stored in the tree metadata, so a registered ID must keep naming
+// the same function. It fails if id is already registered.
+func RegisterHasher[N any](id string, hash Hasher[N]) error {
+	if id == "" {
+		return errors.New("hasher ID is empty")
+	}
+	if hash == nil {
+		return errors.New("parameter 'hash' is not defined")
+	}
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+	if _, ok := hashers[id]; ok {
+		return errors.New("hasher " + id + " is already registered")
+	}
+	hashers[id] = hash
+	return nil
+}
+
+// LookupHasher returns the hasher registered under id. It fails if id is
+// unknown or names a hasher of a different node type.
+func LookupHasher[N any](id string) (Hasher[N], error) {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+	v, ok := hashers[id]
+	if !ok {
+		return nil, errors.New("unknown hasher " + id)
+	}
+	hash, ok := v.(Hasher[N])
+	if !ok {
+		return nil, errors.New("hasher " + id + " has a different node type")
+	}
+	return hash, nil
+}
+
+// RegisteredHashers returns the registered hasher IDs in lexical order.
+func RegisteredHashers() []string {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+	ids := make([]string, 0, len(hashers))
+	for id := range hashers {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// WithHasherID records id as the name of the tree hasher. id must be
+// registered for the hasher passed to New. Trees created with a registered
+// function record its ID without this option; it is needed to pick one of
+// several IDs registered for the same function, and for hashers created by
+// a function such as PoseidonNodeHasher, whose IDs are never inferred.
+//
+// Sync stores the ID in the tree metadata, and New refuses databases whose
+// stored hasher ID is a different one, or that store an ID while the hasher
+// isn't registered, with ErrHasherMismatch. Databases written without an ID
+// are accepted and get it recorded on the next Sync with changes.
+func WithHasherID[N any](id string) Option[N] {
+	return func(t *LeanIMT[N]) {
+		t.hasherID = id
+	}
+}
+
+// NewNamed is like New, with the hasher selected by its registered ID, for
+// instance from a configuration file. The ID is recorded as WithHasherID.
+func NewNamed[N any](hasherID string, eq Equal[N], storage db.Database, encoder func(N) ([]byte, error), decoder func([]byte) (N, error), opts ...Option[N]) (*LeanIMT[N], error) {
+	hash, err := LookupHasher[N](hasherID)
+	if err != nil {
+		return nil, err
+	}
+	return New(hash, eq, storage, encoder, decoder, append(slices.Clip(opts), WithHasherID[N](hasherID))...)
+}
+
+// resolveHasherID returns the ID recorded for hash: id, which must be
+// registered for hash, or if id is empty the lowest ID hash is registered
+// under. Hashers are compared by function. Closures created by the same
+// function literal share it while their captured parameters may differ, so
+// an ID is never inferred for a closure: it returns "" for closures and for
+// hashers that aren't registered.
+func resolveHasherID[N any](hash Hasher[N], id string) (string, error) {
+	fn := reflect.ValueOf(hash).Pointer()
+	if id != "" {
+		registered, err := LookupHasher[N](id)
+		if err != nil {
+			return "", err
+		}
+		if reflect.ValueOf(registered).Pointer() != fn {
+			return "", errors.New("hasher " + id + " is registered for a different function")
+		}
+		return id, nil
+	}
+	if isClosure(fn) {
+		return "", nil
+	}
+
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+	for other, v := range hashers {
+		if h, ok := v.(Hasher[N]); ok && reflect.ValueOf(h).Pointer() == fn && (id == "" || other < id) {
+			id = other
+		}
+	}
+	return id, nil
+}
+
+// closureName matches the names the compiler gives to function literals and
+// method values.
+var closureName = regexp.MustCompile(`\.func\d+(\.\d+)*$|-fm$`)
+
+// isClosure reports whether the function at pc is a function literal or a
+// method value rather than a declared function.
+func isClosure(pc uintptr) bool {
+	f := runtime.FuncForPC(pc)
+	return f == nil || closureName.MatchString(f.Name())
+}
+
+// checkHasher compares the tree hasher ID with the stored one.
+func (t *LeanIMT[N]) checkHasher() error {
+	return checkStoredHasher(t.db, t.hasherID)
+}
+
+// checkStoredHasher compares id with the hasher ID stored in database, if
+// any. An empty id only matches databases without a stored ID.
+func checkStoredHasher(database db.Database, id string) error {
+	stored, err := database.Get([]byte("meta:hasher"))
+	if err == db.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if string(stored) != id {
+		return ErrHasherMismatch
+	}
+	return nil
+}
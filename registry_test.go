@@ -0,0 +1,124 @@
+package leanimt
+
+import (
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
+)
+
+func TestHasherRegistry(t *testing.T) {
+	hash, err := LookupHasher[*big.Int](HasherPoseidon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := big.NewInt(1), big.NewInt(2)
+	if hash(a, b).Cmp(PoseidonHasher(a, b)) != 0 {
+		t.Fatal("registered poseidon differs from PoseidonHasher")
+	}
+	if _, err := LookupHasher[[32]byte](HasherPoseidon); err == nil {
+		t.Fatal("expected an error for the wrong node type")
+	}
+	if _, err := LookupHasher[*big.Int]("unknown"); err == nil {
+		t.Fatal("expected an error for an unknown hasher")
+	}
+
+	// a hasher of its own, so other tests don't record this ID
+	if err := RegisterHasher("test-simple", func(a, b *big.Int) *big.Int { return bigIntHasher(a, b) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterHasher(HasherSHA256, bigIntHasher); err == nil {
+		t.Fatal("expected an error registering an existing ID")
+	}
+	if !slices.Contains(RegisteredHashers(), "test-simple") || !slices.IsSorted(RegisteredHashers()) {
+		t.Fatal("unexpected registered hashers")
+	}
+}
+
+func TestHasherMismatch(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewNamed(HasherSHA256, BigIntEqual, database, BigIntEncoder, BigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.InsertMany(manyLeaves(1, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(Blake2bHasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder,
+		WithHasherID[*big.Int](HasherBlake2b)); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatalf("expected ErrHasherMismatch, got %v", err)
+	}
+	reopened, err := NewNamed(HasherSHA256, BigIntEqual, database, BigIntEncoder, BigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := tree.Root()
+	if got, _ := reopened.Root(); got.Cmp(want) != 0 {
+		t.Fatal("root mismatch after reopening")
+	}
+	// registered hashers are recognized without an ID
+	if _, err := New(Blake2bHasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatalf("expected ErrHasherMismatch, got %v", err)
+	}
+	if _, err := New(SHA256Hasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder); err != nil {
+		t.Fatal(err)
+	}
+	// unregistered hashers can't open a tree with a stored ID
+	if _, err := New(bigIntHasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatalf("expected ErrHasherMismatch, got %v", err)
+	}
+	if _, err := NewDisk(Blake2bHasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder, 0); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatalf("expected ErrHasherMismatch, got %v", err)
+	}
+	if _, err := NewDisk(SHA256Hasher, BigIntEqual, database, BigIntEncoder, BigIntDecoder, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the ID must name the configured hasher
+	if _, err := New(SHA256Hasher, BigIntEqual, nil, nil, nil, WithHasherID[*big.Int](HasherPoseidon)); err == nil {
+		t.Fatal("expected an error for an ID registered for another hasher")
+	}
+	if _, err := New(SHA256Hasher, BigIntEqual, nil, nil, nil, WithHasherID[*big.Int]("unknown")); err == nil {
+		t.Fatal("expected an error for an unknown ID")
+	}
+	if tree, _ := New(PoseidonHasher, BigIntEqual, nil, nil, nil); tree.hasherID != HasherPoseidon {
+		t.Fatalf("hasher ID %q, want %q", tree.hasherID, HasherPoseidon)
+	}
+
+	// IDs are not inferred for closures, which may capture other parameters
+	if err := RegisterHasher("test-node-1", PoseidonNodeHasher(1)); err != nil {
+		t.Fatal(err)
+	}
+	if tree, _ := New(PoseidonNodeHasher(2), BigIntEqual, nil, nil, nil); tree.hasherID != "" {
+		t.Fatalf("hasher ID %q inferred for a closure", tree.hasherID)
+	}
+	nodeDB, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := NewNamed("test-node-1", BigIntEqual, nodeDB, BigIntEncoder, BigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Insert(big.NewInt(1))
+	if err := node.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(PoseidonNodeHasher(2), BigIntEqual, nodeDB, BigIntEncoder, BigIntDecoder); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatalf("expected ErrHasherMismatch, got %v", err)
+	}
+	if _, err := New(PoseidonNodeHasher(1), BigIntEqual, nodeDB, BigIntEncoder, BigIntDecoder,
+		WithHasherID[*big.Int]("test-node-1")); err != nil {
+		t.Fatal(err)
+	}
+}
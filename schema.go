@@ -0,0 +1,163 @@
+package leanimt
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/vocdoni/davinci-node/db"
+)
+
+// SchemaVersion is the version of the tree storage schema written by Sync.
+const SchemaVersion = 1
+
+// ErrUnsupportedSchema is matched by the SchemaError returned when a database
+// was written with a newer storage schema than this package supports.
+var ErrUnsupportedSchema = errors.New("unsupported storage schema version")
+
+// SchemaError reports a database written with an unsupported schema version.
+type SchemaError struct {
+	Key       string // metadata key holding the version
+	Version   int    // version found in the database
+	Supported int    // newest version supported
+}
+
+func (e *SchemaError) Error() string {
+	return "storage schema version " + itoa(e.Version) + " (" + e.Key + ") is newer than the supported version " +
+		itoa(e.Supported) + ", upgrade the software"
+}
+
+// Is reports whether target is ErrUnsupportedSchema.
+func (e *SchemaError) Is(target error) bool {
+	return target == ErrUnsupportedSchema
+}
+
+// Migration upgrades a database from one schema version to the next. It
+// must be idempotent: if the new version can't be recorded, for instance
+// after a crash, it runs again on the partially or fully upgraded database.
+type Migration func(database db.Database) error
+
+// Schema is a versioned storage layout whose version is kept under a
+// metadata key. Upgrade runs the registered migrations to bring older
+// databases to the current version in place.
+type Schema struct {
+	versionKey string
+	dataKey    string
+	version    int
+
+	mu         sync.Mutex
+	migrations map[int]Migration // from version -> migration to from+1
+}
+
+// TreeSchema is the storage schema of LeanIMT and DiskLeanIMT, checked by
+// Load and NewDisk. Databases written before meta:version existed are
+// version 0.
+var TreeSchema = NewSchema("meta:version", "meta:size", SchemaVersion)
+
+func init() {
+	// version 1 only added meta:version
+	_ = TreeSchema.Register(0, func(db.Database) error { return nil })
+}
+
+// NewSchema creates a schema at version whose version is stored under
+// versionKey. A database without versionKey is new if it has no dataKey, and
+// at version 0 otherwise.
+func NewSchema(versionKey, dataKey string, version int) *Schema {
+	return &Schema{
+		versionKey: versionKey,
+		dataKey:    dataKey,
+		version:    version,
+		migrations: make(map[int]Migration),
+	}
+}
+
+// Version returns the current version of the schema.
+func (s *Schema) Version() int {
+	return s.version
+}
+
+// VersionKey returns the metadata key holding the schema version.
+func (s *Schema) VersionKey() string {
+	return s.versionKey
+}
+
+// Register sets the migration upgrading databases from version from to
+// from+1. It fails if from is not older than the current version or already
+// has a migration.
+func (s *Schema) Register(from int, m Migration) error {
+	if from < 0 || from >= s.version {
+		return errors.New("migration from version " + itoa(from) + " is out of range")
+	}
+	if m == nil {
+		return errors.New("parameter 'm' is not defined")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.migrations[from]; ok {
+		return errors.New("migration from version " + itoa(from) + " is already registered")
+	}
+	s.migrations[from] = m
+	return nil
+}
+
+// StoredVersion returns the schema version of database, and false if the
+// database is new.
+func (s *Schema) StoredVersion(database db.Database) (int, bool, error) {
+	value, err := database.Get([]byte(s.versionKey))
+	if err == nil {
+		version, err := strconv.Atoi(string(value))
+		if err != nil || version < 0 {
+			return 0, false, errors.New("malformed schema version in " + s.versionKey)
+		}
+		return version, true, nil
+	}
+	if err != db.ErrKeyNotFound {
+		return 0, false, err
+	}
+	if _, err := database.Get([]byte(s.dataKey)); err != nil {
+		if err == db.ErrKeyNotFound {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return 0, true, nil
+}
+
+// Upgrade checks the schema version of database and runs the migrations
+// needed to reach the current version, recording the version after each of
+// them. New databases are left untouched. It returns a SchemaError if the
+// database is newer than the schema.
+func (s *Schema) Upgrade(database db.Database) error {
+	version, exists, err := s.StoredVersion(database)
+	if err != nil || !exists {
+		return err
+	}
+	if version > s.version {
+		return &SchemaError{Key: s.versionKey, Version: version, Supported: s.version}
+	}
+	for ; version < s.version; version++ {
+		s.mu.Lock()
+		m := s.migrations[version]
+		s.mu.Unlock()
+		if m == nil {
+			return errors.New("no migration from storage schema version " + itoa(version))
+		}
+		if err := m(database); err != nil {
+			return err
+		}
+		if err := s.Stamp(database, version+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stamp records version as the schema version of database.
+func (s *Schema) Stamp(database db.Database, version int) error {
+	tx := database.WriteTx()
+	defer tx.Discard()
+	if err := tx.Set([]byte(s.versionKey), encodeInt(version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,106 @@
+package leanimt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
+)
+
+func TestSchemaUpgrade(t *testing.T) {
+	database, err := metadb.New(db.TypeInMem, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := NewSchema("test:version", "test:data", 3)
+	var ran []int
+	for from := range 3 {
+		if err := schema.Register(from, func(database db.Database) error {
+			ran = append(ran, from)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := schema.Register(1, func(db.Database) error { return nil }); err == nil {
+		t.Fatal("expected an error registering a migration twice")
+	}
+	if err := schema.Register(3, func(db.Database) error { return nil }); err == nil {
+		t.Fatal("expected an error registering a migration from the current version")
+	}
+
+	// new databases are left untouched
+	if err := schema.Upgrade(database); err != nil || len(ran) != 0 {
+		t.Fatalf("new database migrated: %v %v", ran, err)
+	}
+	if _, exists, _ := schema.StoredVersion(database); exists {
+		t.Fatal("new database stamped")
+	}
+
+	// older databases run the remaining migrations in order
+	if err := schema.Stamp(database, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.Upgrade(database); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 2 || ran[0] != 1 || ran[1] != 2 {
+		t.Fatalf("unexpected migrations %v", ran)
+	}
+	if version, _, _ := schema.StoredVersion(database); version != 3 {
+		t.Fatalf("stored version %d, want 3", version)
+	}
+	if err := schema.Upgrade(database); err != nil || len(ran) != 2 {
+		t.Fatal("upgraded database migrated again")
+	}
+
+	if err := schema.Stamp(database, 4); err != nil {
+		t.Fatal(err)
+	}
+	err = schema.Upgrade(database)
+	var schemaErr *SchemaError
+	if !errors.Is(err, ErrUnsupportedSchema) || !errors.As(err, &schemaErr) || schemaErr.Version != 4 {
+		t.Fatalf("expected a SchemaError for version 4, got %v", err)
+	}
+}
+
+func TestTreeSchemaVersion(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, _ := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder)
+	if err := tree.InsertMany(manyLeaves(0, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a database written before meta:version existed is upgraded on load
+	tx := database.WriteTx()
+	if err := tx.Delete([]byte("meta:version")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder); err != nil {
+		t.Fatal(err)
+	}
+	if version, _, _ := TreeSchema.StoredVersion(database); version != SchemaVersion {
+		t.Fatalf("stored version %d, want %d", version, SchemaVersion)
+	}
+
+	// newer databases are refused
+	if err := TreeSchema.Stamp(database, SchemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder); !errors.Is(err, ErrUnsupportedSchema) {
+		t.Fatalf("expected ErrUnsupportedSchema, got %v", err)
+	}
+	if _, err := NewDisk(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder, 0); !errors.Is(err, ErrUnsupportedSchema) {
+		t.Fatalf("expected ErrUnsupportedSchema from NewDisk, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package leanimt
+
+import "slices"
+
+// Snapshot is an immutable point-in-time view of a LeanIMT. It keeps serving
+// the root, proofs, leaves and exports of the moment it was taken, while
+// writers keep modifying the live tree.
+//
+// Snapshots share node levels with the tree (copy-on-write): taking one is
+// O(depth), and the first write to a shared level afterwards copies that
+// level. Appending leaves never copies the leaves level.
+//
+// Snapshot is safe for concurrent use by multiple goroutines.
+type Snapshot[N any] struct {
+	nodes    [][]N
+	values   []N // leaves as inserted, level 0 without a leaf hasher
+	hash     Hasher[N]
+	leafHash LeafHasher[N]
+	eq       Equal[N]
+	hasherID string
+}
+
+// Snapshot returns a read-only view of the current tree state.
+func (t *LeanIMT[N]) Snapshot() *Snapshot[N] {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshotUnsafe()
+}
+
+// tempSnapshot is like Snapshot, for a snapshot used only until the returned
+// release function is called. Unless another snapshot was taken meanwhile,
+// release stops sharing the levels it shared, so later writes don't copy
+// them.
+func (t *LeanIMT[N]) tempSnapshot() (*Snapshot[N], func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	before := slices.Clone(t.shared)
+	s := t.snapshotUnsafe()
+	taken := t.taken
+	return s, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.taken != taken {
+			return // the levels may be shared with a later snapshot
+		}
+		for level := range t.shared {
+			prefix := 0
+			if level < len(before) {
+				prefix = before[level]
+			}
+			// levels copied meanwhile are no longer shared at all
+			t.shared[level] = min(t.shared[level], prefix)
+		}
+	}
+}
+
+// snapshotUnsafe returns a read-only view of the current tree state. The
+// caller must hold the write lock.
+func (t *LeanIMT[N]) snapshotUnsafe() *Snapshot[N] {
+	t.taken++
+	nodes := make([][]N, len(t.nodes))
+	if len(t.shared) < len(t.nodes) {
+		t.shared = append(t.shared, make([]int, len(t.nodes)-len(t.shared))...)
+	}
+	for level := range t.nodes {
+		// cap the view so it can never observe appends from the live tree
+		nodes[level] = t.nodes[level][:len(t.nodes[level]):len(t.nodes[level])]
+		t.shared[level] = max(t.shared[level], len(t.nodes[level]))
+	}
+	values := nodes[0]
+	if t.leafHash != nil {
+		values = t.leaves[:len(t.leaves):len(t.leaves)]
+	}
+	return &Snapshot[N]{nodes: nodes, values: values, hash: t.hash, leafHash: t.leafHash, eq: t.eq, hasherID: t.hasherID}
+}
+
+// unshare copies the given level if a snapshot references the position
+// index, so it can be modified without affecting any snapshot.
+func (t *LeanIMT[N]) unshare(level, index int) {
+	if level >= len(t.shared) || index >= t.shared[level] {
+		return
+	}
+	cp := make([]N, len(t.nodes[level]), cap(t.nodes[level]))
+	copy(cp, t.nodes[level])
+	t.nodes[level] = cp
+	if level == 0 && t.leafHash != nil {
+		// the leaves as inserted are shared along with level 0
+		t.leaves = append(make([]N, 0, cap(t.leaves)), t.leaves...)
+	}
+	t.shared[level] = 0
+}
+
+// Depth returns the depth of the tree when the snapshot was taken.
+func (s *Snapshot[N]) Depth() int {
+	return len(s.nodes) - 1
+}
+
+// Size returns the number of leaves when the snapshot was taken.
+func (s *Snapshot[N]) Size() int {
+	return len(s.nodes[0])
+}
+
+// Root returns the snapshot root and a boolean indicating whether it exists.
+func (s *Snapshot[N]) Root() (N, bool) {
+	return rootOf(s.nodes)
+}
+
+// Leaves returns a copy of the snapshot leaves.
+func (s *Snapshot[N]) Leaves() []N {
+	cp := make([]N, len(s.values))
+	copy(cp, s.values)
+	return cp
+}
+
+// GenerateProof builds a proof for the leaf at index against the snapshot root.
+func (s *Snapshot[N]) GenerateProof(index int) (MerkleProof[N], error) {
+	return generateProof(s.nodes, s.values, index)
+}
+
+// VerifyProof verifies a proof using the snapshot hash and equality functions.
+func (s *Snapshot[N]) VerifyProof(proof MerkleProof[N]) bool {
+	return VerifyProofWithLeafHasher(proof, s.leafHash, s.hash, s.eq)
+}
+
+// Export encodes the snapshot node matrix as JSON, in the same format as
+// LeanIMT.Export.
+func (s *Snapshot[N]) Export() (string, error) {
+	if s.leafHash != nil {
+		return exportNodes(withValues(s.nodes, s.values))
+	}
+	return exportNodes(s.nodes)
+}
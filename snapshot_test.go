@@ -0,0 +1,155 @@
+package leanimt
+
+import (
+	"io"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+func TestSnapshotIsolation(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	leaves := []*big.Int{bigInt(0), bigInt(1), bigInt(2), bigInt(3), bigInt(4)}
+	if err := tree.InsertMany(leaves); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := tree.Snapshot()
+	root, _ := tree.Root()
+	export, err := tree.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every kind of write must leave the snapshot untouched
+	tree.Insert(bigInt(5))
+	if err := tree.Update(4, bigInt(40)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.UpdateMany([]int{0, 1}, []*big.Int{bigInt(10), bigInt(11)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.InsertMany([]*big.Int{bigInt(6), bigInt(7), bigInt(8)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if r, _ := snap.Root(); r.Cmp(root) != 0 {
+		t.Fatalf("snapshot root changed")
+	}
+	if snap.Size() != 5 || snap.Depth() != 3 {
+		t.Fatalf("unexpected snapshot size=%d depth=%d", snap.Size(), snap.Depth())
+	}
+	for i, leaf := range snap.Leaves() {
+		if leaf.Cmp(leaves[i]) != 0 {
+			t.Fatalf("snapshot leaf %d changed", i)
+		}
+	}
+	if got, _ := snap.Export(); got != export {
+		t.Fatalf("snapshot export changed")
+	}
+	for i := range leaves {
+		p, err := snap.GenerateProof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Root.Cmp(root) != 0 || !snap.VerifyProof(p) {
+			t.Fatalf("snapshot proof %d invalid", i)
+		}
+	}
+
+	// the live tree matches a tree built from scratch
+	expected, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	if err := expected.InsertMany([]*big.Int{
+		bigInt(10), bigInt(11), bigInt(2), bigInt(3), bigInt(40),
+		bigInt(5), bigInt(6), bigInt(7), bigInt(8),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r1, _ := tree.Root()
+	r2, _ := expected.Root()
+	if r1.Cmp(r2) != 0 {
+		t.Fatalf("live tree root mismatch after snapshot writes")
+	}
+}
+
+func TestSnapshotConcurrentWriters(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	for i := range 64 {
+		tree.Insert(bigInt(int64(i)))
+	}
+	snap := tree.Snapshot()
+	root, _ := snap.Root()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 200 {
+			tree.Insert(bigInt(int64(i)))
+			if err := tree.Update(i%64, bigInt(int64(1000+i))); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := range 64 {
+		p, err := snap.GenerateProof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Root.Cmp(root) != 0 || !snap.VerifyProof(p) {
+			t.Fatalf("snapshot proof %d invalid during writes", i)
+		}
+	}
+	wg.Wait()
+}
+
+func TestExportReleasesSnapshot(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	if err := tree.InsertMany(manyLeaves(1, 10)); err != nil {
+		t.Fatal(err)
+	}
+	sharing := func() bool {
+		for _, prefix := range tree.shared {
+			if prefix > 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	if err := tree.ExportTo(io.Discard, ExportNodes); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.WriteBinary(io.Discard, BigIntEncoder, BinaryOptions{HasherID: HasherSHA256}); err != nil {
+		t.Fatal(err)
+	}
+	if sharing() {
+		t.Fatal("levels still shared after the exports returned")
+	}
+
+	// snapshots taken meanwhile keep their levels
+	var snap *Snapshot[*big.Int]
+	w := writerFunc(func(p []byte) (int, error) {
+		if snap == nil {
+			snap = tree.Snapshot()
+		}
+		return len(p), nil
+	})
+	if err := tree.ExportTo(w, ExportNodes); err != nil {
+		t.Fatal(err)
+	}
+	root, _ := snap.Root()
+	if err := tree.Update(0, bigInt(100)); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := snap.Root(); r.Cmp(root) != 0 {
+		t.Fatal("snapshot modified by a write after an export")
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
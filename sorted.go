@@ -0,0 +1,316 @@
+package leanimt
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/vocdoni/davinci-node/db"
+)
+
+// ErrLeafExists is returned when inserting a leaf already present in a
+// SortedLeanIMT.
+var ErrLeafExists = errors.New("leaf already exists")
+
+// ErrUnsortedLeaves is returned by NewSorted when the stored leaves are not
+// strictly increasing under the comparator.
+var ErrUnsortedLeaves = errors.New("stored leaves are not sorted")
+
+// Compare orders two leaves, returning a negative number when a < b, zero
+// when a == b and a positive number when a > b. For *big.Int leaves,
+// (*big.Int).Cmp can be used directly.
+type Compare[N any] func(a, b N) int
+
+// SortedLeanIMT is a LeanIMT whose leaves are kept strictly increasing under a
+// comparator, so the absence of a value can be proven by the two adjacent
+// leaves that bracket it. It suits nullifier sets and blocklists.
+//
+// Leaves are stored in order, so inserting a value shifts every larger leaf
+// and rehashes their paths: appending values larger than all the existing
+// ones costs the same as LeanIMT.Insert, inserting at the front rehashes the
+// whole tree.
+type SortedLeanIMT[N any] struct {
+	tree *LeanIMT[N]
+	cmp  Compare[N]
+}
+
+// NonMembershipProof proves that a value is not a leaf of a SortedLeanIMT.
+//   - Root: root at the time of proof
+//   - Size: number of leaves of the tree
+//   - Left: proof of the largest leaf smaller than the value, nil if the
+//     value is smaller than every leaf
+//   - Right: proof of the smallest leaf larger than the value, nil if the
+//     value is larger than every leaf
+type NonMembershipProof[N any] struct {
+	Root  N
+	Size  int
+	Left  *MerkleProof[N]
+	Right *MerkleProof[N]
+}
+
+// NewSorted creates a sorted tree ordered by cmp. Leaf equality is defined by
+// cmp returning zero. Storage, encoder, decoder and options behave as in
+// New; stored leaves must already be sorted.
+func NewSorted[N any](hash Hasher[N], cmp Compare[N], storage db.Database, encoder func(N) ([]byte, error), decoder func([]byte) (N, error), opts ...Option[N]) (*SortedLeanIMT[N], error) {
+	if cmp == nil {
+		return nil, errors.New("parameter 'cmp' is not defined")
+	}
+	eq := func(a, b N) bool { return cmp(a, b) == 0 }
+	tree, err := New(hash, eq, storage, encoder, decoder, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if tree.leafHash != nil {
+		return nil, errors.New("sorted trees don't support leaf hashers")
+	}
+	leaves := tree.nodes[0]
+	for i := 1; i < len(leaves); i++ {
+		if cmp(leaves[i-1], leaves[i]) >= 0 {
+			return nil, ErrUnsortedLeaves
+		}
+	}
+	return &SortedLeanIMT[N]{tree: tree, cmp: cmp}, nil
+}
+
+// Depth returns the current dynamic depth (levels - 1).
+func (s *SortedLeanIMT[N]) Depth() int {
+	return s.tree.Depth()
+}
+
+// Size returns the number of leaves.
+func (s *SortedLeanIMT[N]) Size() int {
+	return s.tree.Size()
+}
+
+// Root returns the current root, if any.
+func (s *SortedLeanIMT[N]) Root() (N, bool) {
+	return s.tree.Root()
+}
+
+// Leaves returns a copy of the leaves in increasing order.
+func (s *SortedLeanIMT[N]) Leaves() []N {
+	return s.tree.Leaves()
+}
+
+// IndexOf returns the index of a leaf using binary search; -1 if not present.
+func (s *SortedLeanIMT[N]) IndexOf(leaf N) int {
+	s.tree.mu.RLock()
+	defer s.tree.mu.RUnlock()
+	if i, found := slices.BinarySearchFunc(s.tree.nodes[0], leaf, s.cmp); found {
+		return i
+	}
+	return -1
+}
+
+// Has returns true if the leaf is present.
+func (s *SortedLeanIMT[N]) Has(leaf N) bool {
+	return s.IndexOf(leaf) >= 0
+}
+
+// Insert adds a leaf at its sorted position and returns that position. It
+// returns ErrLeafExists if the leaf is already present.
+func (s *SortedLeanIMT[N]) Insert(leaf N) (pos int, err error) {
+	t := s.tree
+	op := t.lock(OpInsert)
+	defer func() { t.unlock(op, 1, 0, err) }()
+
+	pos, found := slices.BinarySearchFunc(t.nodes[0], leaf, s.cmp)
+	if found {
+		return pos, ErrLeafExists
+	}
+	tail := make([]N, 0, len(t.nodes[0])-pos+1)
+	tail = append(tail, leaf)
+	tail = append(tail, t.nodes[0][pos:]...)
+	t.replaceFrom(pos, tail)
+	return pos, nil
+}
+
+// InsertMany adds several leaves at their sorted positions, rehashing the
+// tree once from the smallest position. It fails without modifying the tree
+// if any leaf is repeated or already present.
+func (s *SortedLeanIMT[N]) InsertMany(leaves []N) (err error) {
+	t := s.tree
+	op := t.lock(OpInsertMany)
+	defer func() { t.unlock(op, len(leaves), 0, err) }()
+
+	if len(leaves) == 0 {
+		return errors.New("there are no leaves to add")
+	}
+	sorted := slices.Clone(leaves)
+	slices.SortFunc(sorted, s.cmp)
+	for i := 1; i < len(sorted); i++ {
+		if s.cmp(sorted[i-1], sorted[i]) == 0 {
+			return ErrLeafExists
+		}
+	}
+
+	current := t.nodes[0]
+	pos, found := slices.BinarySearchFunc(current, sorted[0], s.cmp)
+	if found {
+		return ErrLeafExists
+	}
+	// merge the new leaves with the existing ones from pos onwards
+	tail := make([]N, 0, len(current)-pos+len(sorted))
+	i, j := pos, 0
+	for i < len(current) && j < len(sorted) {
+		switch c := s.cmp(current[i], sorted[j]); {
+		case c == 0:
+			return ErrLeafExists
+		case c < 0:
+			tail = append(tail, current[i])
+			i++
+		default:
+			tail = append(tail, sorted[j])
+			j++
+		}
+	}
+	tail = append(tail, current[i:]...)
+	tail = append(tail, sorted[j:]...)
+	t.replaceFrom(pos, tail)
+	return nil
+}
+
+// replaceFrom replaces the leaves from pos onwards with tail, which must not
+// be shorter than the replaced leaves nor share their backing array, and
+// rehashes the affected nodes. The caller must hold the write lock.
+func (t *LeanIMT[N]) replaceFrom(pos int, tail []N) {
+	old, _ := t.rootUnsafe()
+	for i := pos; i < len(t.nodes[0]); i++ {
+		t.indexRemove(t.nodes[0][i], i)
+	}
+	t.unshare(0, pos)
+	t.nodes[0] = append(t.nodes[0][:pos], tail...)
+	for i := pos; i < len(t.nodes[0]); i++ {
+		t.indexAdd(t.nodes[0][i], i)
+		t.markLeafUpdated(i)
+	}
+	t.rehashFrom(pos)
+
+	t.markDirty()
+	t.recordRoot()
+	t.notify(old, func() []int { return indexRange(pos, len(t.nodes[0])) })
+}
+
+// GenerateProof builds a membership proof for the leaf at index.
+func (s *SortedLeanIMT[N]) GenerateProof(index int) (MerkleProof[N], error) {
+	return s.tree.GenerateProof(index)
+}
+
+// VerifyProof verifies a membership proof against the tree hash function.
+func (s *SortedLeanIMT[N]) VerifyProof(proof MerkleProof[N]) bool {
+	return s.tree.VerifyProof(proof)
+}
+
+// GenerateNonMembershipProof proves that value is not a leaf of the tree. It
+// returns ErrLeafExists if the value is present.
+func (s *SortedLeanIMT[N]) GenerateNonMembershipProof(value N) (NonMembershipProof[N], error) {
+	t := s.tree
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var empty NonMembershipProof[N]
+	size := len(t.nodes[0])
+	if size == 0 {
+		return empty, errors.New("the tree is empty")
+	}
+	pos, found := slices.BinarySearchFunc(t.nodes[0], value, s.cmp)
+	if found {
+		return empty, ErrLeafExists
+	}
+
+	root, _ := t.rootUnsafe()
+	proof := NonMembershipProof[N]{Root: root, Size: size}
+	if pos > 0 {
+		left, err := generateProof(t.nodes, t.nodes[0], pos-1)
+		if err != nil {
+			return empty, err
+		}
+		proof.Left = &left
+	}
+	if pos < size {
+		right, err := generateProof(t.nodes, t.nodes[0], pos)
+		if err != nil {
+			return empty, err
+		}
+		proof.Right = &right
+	}
+	return proof, nil
+}
+
+// VerifyNonMembershipProof verifies that proof shows value is not a leaf of
+// the tree, using the tree hash function and comparator.
+func (s *SortedLeanIMT[N]) VerifyNonMembershipProof(proof NonMembershipProof[N], value N) bool {
+	return VerifyNonMembershipProofWith(proof, value, s.tree.hash, s.cmp)
+}
+
+// VerifyNonMembershipProofWith verifies a non-membership proof using the
+// provided hash function and comparator. It checks both membership proofs
+// against the proof root, that their leaves bracket value, and that their
+// paths match adjacent positions (or the first and last positions) in a tree
+// of proof.Size leaves. Verifiers should check proof.Size against the size
+// they trust for proof.Root, for instance a RootEntry of the root history.
+func VerifyNonMembershipProofWith[N any](proof NonMembershipProof[N], value N, hash Hasher[N], cmp Compare[N]) bool {
+	if hash == nil || cmp == nil || proof.Size <= 0 || (proof.Left == nil && proof.Right == nil) {
+		return false
+	}
+	eq := func(a, b N) bool { return cmp(a, b) == 0 }
+	check := func(p *MerkleProof[N], index int) bool {
+		bits, siblings := pathBitsFor(index, proof.Size)
+		return p.LeafIndex == uint64(index) && p.PathBits == bits && len(p.Siblings) == siblings &&
+			eq(p.Root, proof.Root) && VerifyProofWith(*p, hash, eq)
+	}
+
+	right := 0 // position of the right neighbour
+	if proof.Left != nil {
+		if proof.Left.LeafIndex >= uint64(proof.Size) {
+			return false
+		}
+		left := int(proof.Left.LeafIndex)
+		if !check(proof.Left, left) || cmp(proof.Left.Leaf, value) >= 0 {
+			return false
+		}
+		right = left + 1
+	}
+	if proof.Right == nil {
+		return right == proof.Size
+	}
+	return right < proof.Size && check(proof.Right, right) && cmp(value, proof.Right.Leaf) < 0
+}
+
+// pathBitsFor returns the path bits and number of siblings of the proof of
+// the leaf at index in a tree with size leaves.
+func pathBitsFor(index, size int) (uint64, int) {
+	var bits uint64
+	siblings := 0
+	for level := range ceilLog2(size) {
+		if index&1 == 1 || index+1 < levelSize(size, level) {
+			if index&1 == 1 {
+				bits |= 1 << uint(siblings)
+			}
+			siblings++
+		}
+		index >>= 1
+	}
+	return bits, siblings
+}
+
+// Subscribe returns a subscription to the root changes committed by Insert
+// and InsertMany. The indices of a change include the leaves shifted by the
+// insertion.
+func (s *SortedLeanIMT[N]) Subscribe(buffer int, policy Backpressure) *Subscription[N] {
+	return s.tree.Subscribe(buffer, policy)
+}
+
+// Snapshot returns a read-only view of the tree at its current state.
+func (s *SortedLeanIMT[N]) Snapshot() *Snapshot[N] {
+	return s.tree.Snapshot()
+}
+
+// Sync persists the tree to the database, if any.
+func (s *SortedLeanIMT[N]) Sync() error {
+	return s.tree.Sync()
+}
+
+// Close syncs the tree and closes the database connection.
+func (s *SortedLeanIMT[N]) Close() error {
+	return s.tree.Close()
+}
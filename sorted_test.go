@@ -0,0 +1,153 @@
+package leanimt
+
+import (
+	"math/big"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/vocdoni/davinci-node/db"
+	"github.com/vocdoni/davinci-node/db/metadb"
+)
+
+func TestSortedInsertMatchesSortedTree(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	sorted, _ := NewSorted(bigIntHasher, (*big.Int).Cmp, nil, nil, nil, WithKeyIndex(BigIntKey))
+	var values []int64
+	for len(values) < 60 {
+		v := rng.Int64N(1000)
+		if slices.Contains(values, v) {
+			if _, err := sorted.Insert(bigInt(v)); err != ErrLeafExists {
+				t.Fatalf("duplicate %d: got %v", v, err)
+			}
+			continue
+		}
+		values = append(values, v)
+		if _, err := sorted.Insert(bigInt(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch := []*big.Int{bigInt(1500), bigInt(-3), bigInt(1001)}
+	if err := sorted.InsertMany(batch); err != nil {
+		t.Fatal(err)
+	}
+	values = append(values, 1500, -3, 1001)
+	if err := sorted.InsertMany([]*big.Int{bigInt(2000), bigInt(values[0])}); err != ErrLeafExists {
+		t.Fatalf("batch with existing leaf: got %v", err)
+	}
+	slices.Sort(values)
+
+	plain, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	for _, v := range values {
+		plain.Insert(bigInt(v))
+	}
+	want, _ := plain.Root()
+	if got, _ := sorted.Root(); got.Cmp(want) != 0 {
+		t.Fatal("sorted tree root differs from a tree built from sorted leaves")
+	}
+	for i, v := range values {
+		if sorted.IndexOf(bigInt(v)) != i {
+			t.Fatalf("IndexOf(%d) != %d", v, i)
+		}
+	}
+	if sorted.tree.IndexOf(bigInt(values[5])) != 5 {
+		t.Fatal("key index not updated after shifting leaves")
+	}
+}
+
+func TestNonMembershipProof(t *testing.T) {
+	sorted, _ := NewSorted(bigIntHasher, (*big.Int).Cmp, nil, nil, nil)
+	for _, v := range []int64{10, 20, 30, 40, 50} {
+		if _, err := sorted.Insert(bigInt(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, v := range []int64{5, 15, 35, 45, 55} {
+		proof, err := sorted.GenerateNonMembershipProof(bigInt(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sorted.VerifyNonMembershipProof(proof, bigInt(v)) {
+			t.Fatalf("non-membership of %d rejected", v)
+		}
+		neighbour := proof.Right
+		if neighbour == nil {
+			neighbour = proof.Left
+		}
+		if sorted.VerifyNonMembershipProof(proof, neighbour.Leaf) {
+			t.Fatalf("proof for %d accepted for leaf %v", v, neighbour.Leaf)
+		}
+	}
+	if _, err := sorted.GenerateNonMembershipProof(bigInt(30)); err != ErrLeafExists {
+		t.Fatalf("existing leaf: got %v", err)
+	}
+
+	// neighbours that are valid leaves but not adjacent must be rejected
+	proof, _ := sorted.GenerateNonMembershipProof(bigInt(25))
+	far, _ := sorted.GenerateProof(3)
+	proof.Right = &far
+	if sorted.VerifyNonMembershipProof(proof, bigInt(25)) {
+		t.Fatal("non-adjacent neighbours accepted")
+	}
+	// a leaf claiming another position must be rejected
+	proof, _ = sorted.GenerateNonMembershipProof(bigInt(55))
+	moved := *proof.Left
+	moved.LeafIndex = 3
+	proof.Left = &moved
+	proof.Size = 4
+	if sorted.VerifyNonMembershipProof(proof, bigInt(55)) {
+		t.Fatal("proof with forged index accepted")
+	}
+	// the last leaf must really be the last one
+	proof, _ = sorted.GenerateNonMembershipProof(bigInt(45))
+	proof.Right = nil
+	if sorted.VerifyNonMembershipProof(proof, bigInt(45)) {
+		t.Fatal("missing right neighbour accepted")
+	}
+}
+
+func TestSortedPersistence(t *testing.T) {
+	database, err := metadb.New(db.TypePebble, createTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted, err := NewSorted(bigIntHasher, (*big.Int).Cmp, database, bigIntEncoder, bigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []int64{7, 3, 9, 1} {
+		if _, err := sorted.Insert(bigInt(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sorted.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// a middle insertion rewrites shifted leaves incrementally
+	if _, err := sorted.Insert(bigInt(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sorted.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := sorted.Root()
+
+	reloaded, err := NewSorted(bigIntHasher, (*big.Int).Cmp, database, bigIntEncoder, bigIntDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reloaded.Root(); got.Cmp(want) != 0 {
+		t.Fatal("root mismatch after reload")
+	}
+
+	// an unsorted tree in the same database is refused
+	plain, _ := New(bigIntHasher, BigIntEqual, database, bigIntEncoder, bigIntDecoder)
+	plain.Insert(bigInt(0))
+	if err := plain.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSorted(bigIntHasher, (*big.Int).Cmp, database, bigIntEncoder, bigIntDecoder); err != ErrUnsortedLeaves {
+		t.Fatalf("unsorted leaves: got %v", err)
+	}
+}
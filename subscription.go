@@ -0,0 +1,151 @@
+package leanimt
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// RootChange describes a committed change of the tree root.
+//   - OldRoot: root before the change, the zero value if the tree was empty
+//   - NewRoot: root after the change
+//   - Size: number of leaves after the change
+//   - Indices: positions of the inserted or updated leaves, in increasing order
+type RootChange[N any] struct {
+	OldRoot N
+	NewRoot N
+	Size    int
+	Indices []int
+}
+
+// Backpressure selects what a subscription does when its buffer is full.
+type Backpressure int
+
+const (
+	// DropOldest discards the oldest buffered change to make room for the
+	// new one, so a slow subscriber always sees the latest root.
+	DropOldest Backpressure = iota
+	// DropNewest discards the new change, keeping the buffered ones.
+	DropNewest
+)
+
+// RootFeed delivers root changes to subscribers. The zero value is ready to
+// use. Publish never blocks: changes that do not fit in a subscription buffer
+// are dropped according to its Backpressure policy and counted in Dropped.
+type RootFeed[N any] struct {
+	mu     sync.Mutex
+	subs   map[*Subscription[N]]struct{}
+	active atomic.Int32
+}
+
+// Subscription receives root changes from a RootFeed until closed.
+type Subscription[N any] struct {
+	feed    *RootFeed[N]
+	ch      chan RootChange[N]
+	policy  Backpressure
+	dropped atomic.Uint64
+}
+
+// Subscribe registers a subscription buffering up to buffer changes (at
+// least one).
+func (f *RootFeed[N]) Subscribe(buffer int, policy Backpressure) *Subscription[N] {
+	s := &Subscription[N]{feed: f, ch: make(chan RootChange[N], max(buffer, 1)), policy: policy}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[*Subscription[N]]struct{})
+	}
+	f.subs[s] = struct{}{}
+	f.active.Add(1)
+	return s
+}
+
+// Active reports whether the feed has subscribers, so publishers can skip
+// building changes nobody receives.
+func (f *RootFeed[N]) Active() bool {
+	return f.active.Load() > 0
+}
+
+// Publish delivers change to every subscription. Subscribers share the
+// Indices slice and must not modify it.
+func (f *RootFeed[N]) Publish(change RootChange[N]) {
+	if !f.Active() {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		s.send(change)
+	}
+}
+
+// send buffers change, dropping a change if the buffer is full. The caller
+// must hold the feed lock.
+func (s *Subscription[N]) send(change RootChange[N]) {
+	for {
+		select {
+		case s.ch <- change:
+			return
+		default:
+		}
+		if s.policy == DropNewest {
+			s.dropped.Add(1)
+			return
+		}
+		select {
+		case <-s.ch:
+			s.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// C returns the channel delivering the changes. It is closed by Close.
+func (s *Subscription[N]) C() <-chan RootChange[N] {
+	return s.ch
+}
+
+// Dropped returns the number of changes discarded because the buffer was
+// full.
+func (s *Subscription[N]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close unregisters the subscription and closes its channel. It is safe to
+// call more than once.
+func (s *Subscription[N]) Close() {
+	f := s.feed
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[s]; !ok {
+		return
+	}
+	delete(f.subs, s)
+	f.active.Add(-1)
+	close(s.ch)
+}
+
+// Subscribe returns a subscription to the root changes committed by Insert,
+// InsertMany, Update and UpdateMany. Changes are published in commit order
+// while the tree is locked, without waiting for subscribers.
+func (t *LeanIMT[N]) Subscribe(buffer int, policy Backpressure) *Subscription[N] {
+	return t.feed.Subscribe(buffer, policy)
+}
+
+// notify publishes the change from old to the current root. indices is only
+// called if there are subscribers. The caller must hold the write lock.
+func (t *LeanIMT[N]) notify(old N, indices func() []int) {
+	if !t.feed.Active() {
+		return
+	}
+	root, _ := t.rootUnsafe()
+	t.feed.Publish(RootChange[N]{OldRoot: old, NewRoot: root, Size: len(t.nodes[0]), Indices: indices()})
+}
+
+// indexRange returns the indices [from, to).
+func indexRange(from, to int) []int {
+	out := make([]int, to-from)
+	for i := range out {
+		out[i] = from + i
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package leanimt
+
+import (
+	"math/big"
+	"slices"
+	"testing"
+)
+
+func TestSubscribeRootChanges(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	sub := tree.Subscribe(8, DropOldest)
+	defer sub.Close()
+
+	tree.Insert(bigInt(1))
+	change := <-sub.C()
+	root, _ := tree.Root()
+	if change.OldRoot != nil || change.NewRoot.Cmp(root) != 0 || change.Size != 1 || !slices.Equal(change.Indices, []int{0}) {
+		t.Fatalf("unexpected insert change %+v", change)
+	}
+
+	if err := tree.InsertMany(manyLeaves(2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	change = <-sub.C()
+	if change.OldRoot.Cmp(root) != 0 || change.Size != 4 || !slices.Equal(change.Indices, []int{1, 2, 3}) {
+		t.Fatalf("unexpected insert many change %+v", change)
+	}
+	root = change.NewRoot
+
+	if err := tree.Update(2, bigInt(10)); err != nil {
+		t.Fatal(err)
+	}
+	change = <-sub.C()
+	if change.OldRoot.Cmp(root) != 0 || !slices.Equal(change.Indices, []int{2}) {
+		t.Fatalf("unexpected update change %+v", change)
+	}
+	if err := tree.UpdateMany([]int{3, 0}, []*big.Int{bigInt(20), bigInt(30)}); err != nil {
+		t.Fatal(err)
+	}
+	if change = <-sub.C(); !slices.Equal(change.Indices, []int{0, 3}) {
+		t.Fatalf("unexpected update many change %+v", change)
+	}
+
+	// failed operations publish nothing
+	if err := tree.Update(10, bigInt(0)); err == nil {
+		t.Fatal("expected an error")
+	}
+	select {
+	case change := <-sub.C():
+		t.Fatalf("unexpected change %+v", change)
+	default:
+	}
+
+	sub.Close()
+	sub.Close()
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("channel not closed")
+	}
+	tree.Insert(bigInt(5))
+}
+
+func TestSubscriptionBackpressure(t *testing.T) {
+	tree, _ := New(bigIntHasher, BigIntEqual, nil, nil, nil)
+	oldest := tree.Subscribe(2, DropOldest)
+	newest := tree.Subscribe(2, DropNewest)
+	for i := range 5 {
+		tree.Insert(bigInt(int64(i)))
+	}
+
+	if oldest.Dropped() != 3 || newest.Dropped() != 3 {
+		t.Fatalf("dropped %d and %d, want 3", oldest.Dropped(), newest.Dropped())
+	}
+	// DropOldest keeps the latest changes, DropNewest the first ones
+	if a, b := <-oldest.C(), <-oldest.C(); a.Size != 4 || b.Size != 5 {
+		t.Fatalf("DropOldest kept sizes %d and %d", a.Size, b.Size)
+	}
+	if a, b := <-newest.C(), <-newest.C(); a.Size != 1 || b.Size != 2 {
+		t.Fatalf("DropNewest kept sizes %d and %d", a.Size, b.Size)
+	}
+	oldest.Close()
+	newest.Close()
+	if tree.feed.Active() {
+		t.Fatal("feed still active")
+	}
+}
+
+func TestSortedSubscribe(t *testing.T) {
+	tree, _ := NewSorted(bigIntHasher, (*big.Int).Cmp, nil, nil, nil)
+	sub := tree.Subscribe(4, DropOldest)
+	defer sub.Close()
+	if err := tree.InsertMany([]*big.Int{bigInt(10), bigInt(30)}); err != nil {
+		t.Fatal(err)
+	}
+	<-sub.C()
+	if _, err := tree.Insert(bigInt(20)); err != nil {
+		t.Fatal(err)
+	}
+	if change := <-sub.C(); change.Size != 3 || !slices.Equal(change.Indices, []int{1, 2}) {
+		t.Fatalf("unexpected change %+v", change)
+	}
+}
@@ -0,0 +1,70 @@
+// Generates ../vectors.json with the zk-kit TypeScript LeanIMT and Poseidon.
+//
+//   npm install && node generate.mjs
+//
+// Every case inserts `leaves` in order, applies `updates`, and records the
+// resulting root, export and the proofs of `proofIndices`.
+import { readFileSync, writeFileSync } from "node:fs"
+import { dirname, join } from "node:path"
+import { fileURLToPath } from "node:url"
+import { LeanIMT } from "@zk-kit/lean-imt"
+import { poseidon2 } from "poseidon-lite"
+
+const hash = (a, b) => poseidon2([a, b])
+
+// deterministic field elements, small enough to stay below the BN254 modulus
+let state = 0x2545f4914f6cdd1dn
+const next = () => {
+    state = (state * 6364136223846793005n + 1442695040888963407n) % (1n << 248n)
+    return state
+}
+
+const sizes = [...Array(33).keys()].map((i) => i + 1).concat([63, 64, 65, 100, 127, 128, 129, 255, 256, 257, 1000])
+
+const cases = []
+for (const size of sizes) {
+    const leaves = Array.from({ length: size }, next)
+    const tree = new LeanIMT(hash)
+    tree.insertMany(leaves)
+
+    const updates = []
+    if (size > 2) {
+        for (const index of [0, Math.floor(size / 2), size - 1]) {
+            const leaf = next()
+            tree.update(index, leaf)
+            updates.push({ index, leaf: leaf.toString() })
+        }
+    }
+
+    const proofIndices =
+        size <= 33 ? [...Array(size).keys()] : [0, 1, Math.floor(size / 3), Math.floor(size / 2), size - 2, size - 1]
+    const proofs = proofIndices.map((i) => {
+        const p = tree.generateProof(i)
+        return {
+            leafIndex: i,
+            root: p.root.toString(),
+            leaf: p.leaf.toString(),
+            // zk-kit returns NaN when there are no siblings
+            index: Number.isNaN(p.index) ? 0 : p.index,
+            siblings: p.siblings.map((s) => s.toString())
+        }
+    })
+
+    cases.push({
+        name: `size-${size}`,
+        leaves: leaves.map((l) => l.toString()),
+        updates,
+        root: tree.root.toString(),
+        depth: tree.depth,
+        export: tree.export(),
+        proofs
+    })
+}
+
+const here = dirname(fileURLToPath(import.meta.url))
+const version = (pkg) => JSON.parse(readFileSync(join(here, "node_modules", pkg, "package.json"))).version
+const generator = `@zk-kit/lean-imt ${version("@zk-kit/lean-imt")}, poseidon-lite ${version("poseidon-lite")}`
+
+const out = join(here, "..", "vectors.json")
+writeFileSync(out, `${JSON.stringify({ generator, cases }, null, 1)}\n`)
+console.log(`wrote ${cases.length} cases to ${out}`)